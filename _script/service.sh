@@ -70,6 +70,9 @@ After=network-online.target
 # Environment="HTTP_PROXY=http://localhost:1080"
 # Environment="HTTPS_PROXY=http://localhost:1080"
 DynamicUser=yes
+# keep the notification queue, the punch state and the history in /var/lib/healthreport
+StateDirectory=healthreport
+WorkingDirectory=%S/healthreport
 LoadCredential=account.json:${parentDir}/account.json
 ${loadEmail}
 ExecStart=${execStart}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/yin1999/healthreport/v2/utils/history"
+)
+
+// errNotLocal the api address is not a loopback address or a unix socket
+var errNotLocal = errors.New("api: only localhost or unix socket is allowed")
+
+// api the local http control api of the daemon
+type api struct {
+	d      *daemon
+	reload func() error
+}
+
+// listenAPI listen on a loopback tcp address, or a unix socket with `unix:` prefix
+func listenAPI(addr string) (net.Listener, error) {
+	if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+		// remove the socket left by the last run
+		if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !isLoopback(host) {
+		return nil, errNotLocal
+	}
+	return net.Listen("tcp", addr)
+}
+
+// isLoopback report whether the host is localhost or a loopback ip
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// serveAPI serve the control api on addr in the background
+func serveAPI(addr string, a *api) (*http.Server, error) {
+	l, err := listenAPI(addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: localOnly(a.handler(true))}
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			logger.Printf("API server stopped, err: %s\n", err.Error())
+		}
+	}()
+	logger.Printf("API listening on %s\n", addr)
+	return srv, nil
+}
+
+// handler return the handler of the api, /reload is served only if reload is set
+func (a *api) handler(reload bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", method(http.MethodGet, a.status))
+	mux.HandleFunc("/punch/", method(http.MethodPost, a.punch))
+	mux.HandleFunc("/history", method(http.MethodGet, a.history))
+	if reload {
+		mux.HandleFunc("/reload", method(http.MethodPost, a.reloadConfig))
+	}
+	return mux
+}
+
+// localOnly reject the requests to a non-loopback Host, which a DNS rebinding page
+// makes, and the cross-origin requests since the api has no credential
+func localOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isLoopback(strings.Trim(host, "[]")) {
+			writeError(w, http.StatusForbidden, errors.New("non-local host"))
+			return
+		}
+		if !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, errors.New("cross-origin request"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// method reject the requests of other methods
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// status GET /status
+func (a *api) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.d.status())
+}
+
+// punch POST /punch/{account}
+func (a *api) punch(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/punch/")
+	switch err := a.d.trigger(name); err {
+	case nil:
+		logger.Printf("Manual punch of %s requested by API\n", name)
+		writeJSON(w, http.StatusAccepted, map[string]string{"account": name, "status": "triggered"})
+	case errAccountNotFound:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusConflict, err)
+	}
+}
+
+// reloadConfig POST /reload
+func (a *api) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	if err := a.reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.d.status())
+}
+
+// history GET /history?account=&from=&to=, the dates are in YYYY-MM-DD format and included
+func (a *api) history(w http.ResponseWriter, r *http.Request) {
+	if a.d.history == nil {
+		writeError(w, http.StatusNotFound, errors.New("history is disabled"))
+		return
+	}
+	query := r.URL.Query()
+	filter := history.Filter{Account: query.Get("account")}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = parseDay(from); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = parseDay(to); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	records, err := a.d.history.Query(filter)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	history.WriteJSON(w, records)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
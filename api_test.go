@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalOnly(t *testing.T) {
+	a := &api{d: newDaemon("", "", ""), reload: func() error { return nil }}
+	h := localOnly(a.handler(true))
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		host   string
+		origin string
+		code   int
+	}{
+		{"loopback", http.MethodGet, "/status", "127.0.0.1:8080", "", http.StatusOK},
+		{"localhost", http.MethodGet, "/status", "localhost:8080", "", http.StatusOK},
+		{"ipv6 loopback", http.MethodGet, "/status", "[::1]:8080", "", http.StatusOK},
+		{"same origin", http.MethodPost, "/reload", "127.0.0.1:8080", "http://127.0.0.1:8080", http.StatusOK},
+		{"rebinding host", http.MethodGet, "/status", "evil.example.com:8080", "", http.StatusForbidden},
+		{"non-local ip", http.MethodPost, "/reload", "192.168.1.2:8080", "", http.StatusForbidden},
+		{"cross origin", http.MethodPost, "/reload", "127.0.0.1:8080", "http://evil.example.com", http.StatusForbidden},
+		{"cross origin port", http.MethodPost, "/punch/test", "127.0.0.1:8080", "http://127.0.0.1:9090", http.StatusForbidden},
+		{"unknown account", http.MethodPost, "/punch/test", "127.0.0.1:8080", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			r.Host = test.host
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != test.code {
+				t.Errorf("expect status %d, got %d: %s", test.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListenAPI(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "example.com:0"} {
+		if l, err := listenAPI(addr); err != errNotLocal {
+			if err == nil {
+				l.Close()
+			}
+			t.Errorf("%s: expect %v, got %v", addr, errNotLocal, err)
+		}
+	}
+	l, err := listenAPI("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
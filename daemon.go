@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	client "github.com/yin1999/healthreport/v2/httpclient"
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils"
+	"github.com/yin1999/healthreport/v2/utils/config"
+	"github.com/yin1999/healthreport/v2/utils/history"
+	"github.com/yin1999/healthreport/v2/utils/notify"
+)
+
+var (
+	errAccountNotFound  = errors.New("account not found")
+	errNotRunning       = errors.New("punch service is not running")
+	errAlreadyTriggered = errors.New("a manual punch is pending")
+)
+
+// runWorker run the punch service of the worker, replaced in tests
+var runWorker = (*daemon).run
+
+// worker a punch service running for a single account
+type worker struct {
+	spec    config.Account
+	cancel  context.CancelFunc
+	done    chan struct{}
+	trigger chan struct{} // a manual punch request, buffered by 1
+
+	mux    sync.Mutex
+	next   time.Time     // the next scheduled punch
+	last   *serve.Record // the last punch attempt
+	streak int           // the number of the consecutive failed attempts
+}
+
+// accountStatus the status of the punch service of an account
+type accountStatus struct {
+	Account       string        `json:"account"`
+	Schedule      string        `json:"schedule"`
+	Running       bool          `json:"running"`
+	NextRun       *time.Time    `json:"nextRun,omitempty"`
+	LastSuccess   *time.Time    `json:"lastSuccess,omitempty"`
+	LastResult    *serve.Record `json:"lastResult,omitempty"`
+	FailureStreak int           `json:"failureStreak"`
+}
+
+// Record implement serve.Recorder, keep the last attempt
+func (w *worker) Record(r serve.Record) error {
+	w.mux.Lock()
+	w.last = &r
+	w.streak = nextStreak(w.streak, r)
+	w.mux.Unlock()
+	return nil
+}
+
+// nextStreak return the failure streak after the record, the skipped punches are ignored
+func nextStreak(streak int, r serve.Record) int {
+	switch r.Status {
+	case serve.StatusFailed:
+		return streak + 1
+	case serve.StatusSkipped:
+		return streak
+	}
+	return 0
+}
+
+// restore restore the last attempt and the failure streak from the history
+func (w *worker) restore(records []serve.Record) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	for i := range records {
+		w.last = &records[i]
+		w.streak = nextStreak(w.streak, records[i])
+	}
+}
+
+func (w *worker) scheduled(at time.Time) {
+	w.mux.Lock()
+	w.next = at
+	w.mux.Unlock()
+}
+
+func (w *worker) status() accountStatus {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	s := accountStatus{
+		Account:       w.spec.Username,
+		Schedule:      w.spec.PunchTime.String(),
+		Running:       w.running(),
+		LastResult:    w.last,
+		FailureStreak: w.streak,
+	}
+	if s.Running && !w.next.IsZero() {
+		next := w.next
+		s.NextRun = &next
+	}
+	return s
+}
+
+// running report whether the worker is still running
+func (w *worker) running() bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *worker) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// daemon manage the punch services of all the accounts
+type daemon struct {
+	mux        sync.Mutex
+	workers    map[string]*worker
+	notifier   notify.Notifiers       // the notification channels shared by all the accounts
+	digests    *notify.Collector      // the punch results for the digests
+	stopDigest context.CancelFunc     // stop sending the digests of the current channels
+	sessions   *client.SessionManager // shared by all the accounts
+	state      *serve.StateStore      // the last success of the accounts
+	history    *history.Store         // nil if the history is disabled
+	queue      *notify.Queue          // nil if the notifications are sent directly
+	queueDone  chan struct{}          // closed when the queue runner exits
+}
+
+func newDaemon(sessionDir, stateDir, historyFile string) *daemon {
+	d := &daemon{
+		workers:  make(map[string]*worker),
+		sessions: client.NewSessionManager(sessionDir),
+		state:    serve.NewStateStore(stateDir),
+		digests:  notify.NewCollector(),
+	}
+	if historyFile != "" {
+		d.history = history.New(historyFile)
+	}
+	return d
+}
+
+// apply start the services of new accounts, restart the services of
+// changed or stopped accounts and stop the services of removed accounts.
+// The services of unchanged accounts keep running.
+func (d *daemon) apply(ctx context.Context, accounts []config.Account, notifier notify.Notifiers) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.notifier = notifier
+	if d.queue != nil {
+		d.queue.SetChannels(notifier)
+	}
+	if d.stopDigest != nil {
+		d.stopDigest()
+	}
+	var digestCtx context.Context
+	digestCtx, d.stopDigest = context.WithCancel(ctx)
+	go d.digests.Run(digestCtx, notifier, timeZone, d.sendDigest, func(channel string, err error) {
+		logger.Printf("Send digest to %s failed, err: %s\n", channel, err.Error())
+	})
+
+	// the workers are stopped without holding the lock, their notifications take it
+	var (
+		stopped []*worker
+		starts  []config.Account
+	)
+	keep := make(map[string]struct{}, len(accounts))
+	for _, spec := range accounts {
+		keep[spec.Username] = struct{}{}
+		if w, ok := d.workers[spec.Username]; ok {
+			if w.running() && reflect.DeepEqual(w.spec, spec) {
+				continue
+			}
+			stopped = append(stopped, w)
+		}
+		starts = append(starts, spec)
+	}
+	for name, w := range d.workers {
+		if _, ok := keep[name]; !ok {
+			stopped = append(stopped, w)
+			delete(d.workers, name)
+			logger.Printf("Account %s removed\n", name)
+		}
+	}
+	d.mux.Unlock()
+	for _, w := range stopped {
+		w.stop()
+	}
+	d.mux.Lock()
+	for _, spec := range starts {
+		d.workers[spec.Username] = d.start(ctx, spec)
+	}
+}
+
+// wait wait for all the services to exit
+func (d *daemon) wait() {
+	d.mux.Lock()
+	workers := make([]*worker, 0, len(d.workers))
+	for _, w := range d.workers {
+		workers = append(workers, w)
+	}
+	d.mux.Unlock()
+	for _, w := range workers {
+		<-w.done
+	}
+}
+
+// status return the status of the accounts sorted by name
+func (d *daemon) status() []accountStatus {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	list := make([]accountStatus, 0, len(d.workers))
+	for _, w := range d.workers {
+		s := w.status()
+		if t, ok := d.state.LastSuccess(s.Account); ok {
+			s.LastSuccess = &t
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Account < list[j].Account })
+	return list
+}
+
+// trigger request a manual punch of the account out of the schedule
+func (d *daemon) trigger(name string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	w, ok := d.workers[name]
+	switch {
+	case !ok:
+		return errAccountNotFound
+	case !w.running():
+		return errNotRunning
+	}
+	select {
+	case w.trigger <- struct{}{}:
+		return nil
+	default:
+		return errAlreadyTriggered
+	}
+}
+
+// sendDigest queue the digest for the channel, or send it directly without the queue
+func (d *daemon) sendDigest(ch notify.Channel, e serve.Event) error {
+	if d.queue != nil {
+		err := d.queue.EnqueueChannel(ch.Name, e)
+		if err == nil {
+			return nil
+		}
+		logger.Printf("Queue digest failed, send it directly, err: %s\n", err.Error())
+	}
+	return ch.Notify(e)
+}
+
+// notify deliver the event to the channels, the email receivers are replaced by to if not empty,
+// the event is queued if the queue is enabled, and sent directly if it cannot be queued
+func (d *daemon) notify(e serve.Event, to []string) error {
+	d.digests.Add(e)
+	if d.queue != nil {
+		err := d.queue.Enqueue(e, to)
+		if err == nil {
+			return nil
+		}
+		logger.Printf("Queue message failed, send it directly, err: %s\n", err.Error())
+	}
+	d.mux.Lock()
+	n := d.notifier
+	d.mux.Unlock()
+	return n.WithReceivers(to).Notify(e)
+}
+
+// runQueue deliver the queued notifications in the background until the context is canceled
+func (d *daemon) runQueue(ctx context.Context) {
+	if d.queue == nil {
+		return
+	}
+	d.queueDone = make(chan struct{})
+	go func() {
+		defer close(d.queueDone)
+		d.queue.Run(ctx, logQueueError)
+	}()
+}
+
+// flush wait for the queue runner to exit, then try to deliver the
+// pending notifications within the timeout
+func (d *daemon) flush(timeout time.Duration) {
+	if d.queue == nil {
+		return
+	}
+	if d.queueDone != nil {
+		<-d.queueDone
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	n, err := d.queue.Flush(ctx, logQueueError)
+	if err != nil {
+		logger.Printf("Flush the notification queue failed, err: %s\n", err.Error())
+	}
+	if n != 0 {
+		logger.Printf("%d notification(s) left in the queue, they will be sent at the next start\n", n)
+	}
+}
+
+func logQueueError(m notify.Message, err error) {
+	if m.Key == "" {
+		logger.Printf("Notification queue: %s\n", err.Error())
+		return
+	}
+	logger.Printf("Send message %s to %s failed(attempt %d), err: %s\n", m.Key, m.Channel, m.Attempts, err.Error())
+}
+
+func (d *daemon) start(ctx context.Context, spec config.Account) *worker {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &worker{
+		spec:    spec,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		trigger: make(chan struct{}, 1),
+	}
+	if d.history != nil {
+		records, err := d.history.Query(history.Filter{Account: spec.Username})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Printf("Load the punch history of %s failed, err: %s\n", spec.Username, err.Error())
+		}
+		w.restore(records)
+	}
+	go func() {
+		defer close(w.done)
+		runWorker(d, ctx, w)
+	}()
+	return w
+}
+
+// run run the punch service for an account, the error is logged
+// and only stops the service of this account
+func (d *daemon) run(ctx context.Context, w *worker) {
+	spec := w.spec
+	l := log.New(logger.Writer(), "["+spec.Username+"] ", logger.Flags()|log.Lmsgprefix)
+	account := &client.Account{
+		Username: spec.Username,
+		Password: spec.Password,
+		Answers:  spec.Answers,
+		Force:    spec.Force,
+	}
+
+	serveCfg := &serve.Config{
+		Notifier: serve.NotifierFunc(func(e serve.Event) error {
+			return d.notify(e, spec.Notify)
+		}),
+		Logger:      l,
+		MaxAttempts: spec.MaxAttempts,
+		Time:        serve.Time{TimeZone: timeZone},
+		Schedule:    spec.PunchTime.Schedule,
+		Timeout:     punchTimeout,
+		Retry:       retryPolicy(*spec.Retry),
+		PunchFunc:   d.sessions.Punch,
+		Startup:     *spec.Startup,
+		State:       d.state,
+		Recorder:    recorders{w, metricsRecorder{}},
+		Scheduled: func(_ serve.Account, at time.Time) {
+			w.scheduled(at)
+			nextPunch.With(spec.Username).Set(float64(at.Unix()))
+		},
+		Trigger: w.trigger,
+	}
+	if d.history != nil {
+		serveCfg.Recorder = recorders{w, d.history, metricsRecorder{}}
+	}
+	if t, ok := d.state.LastSuccess(spec.Username); ok {
+		lastSuccess.With(spec.Username).Set(float64(t.Unix()))
+	}
+	defer nextPunch.Delete(spec.Username)
+	if len(spec.Holidays) != 0 {
+		serveCfg.Calendar = serve.Holidays(spec.Holidays)
+	}
+	if t := spec.Retry.GiveUpAt; t != nil {
+		serveCfg.Deadline = &serve.Time{Hour: t.Hour, Minute: t.Minute, TimeZone: timeZone}
+	}
+
+	// the transient errors(e.g. network or captcha) are retried, the account is
+	// stopped on the permanent ones(notified at once) until the config is reloaded
+	l.Print("正在验证账号密码\n")
+	if err := serveCfg.Confirm(ctx, account, d.sessions.LoginConfirm); err != nil {
+		if err != context.Canceled {
+			l.Printf("验证密码失败(Err: %s)\n", err.Error())
+		}
+		return
+	}
+	l.Printf("账号密码验证成功，将在5秒后开始打卡(Time set: %s, Maximum number of attempts: %d)\n",
+		spec.PunchTime, spec.MaxAttempts)
+	if utils.Wait(ctx, 5*time.Second) != nil {
+		return
+	}
+
+	err := serveCfg.PunchServe(ctx, account)
+	if err != nil && err != context.Canceled {
+		l.Printf("Punch service stopped, err: %s\n", err.Error())
+	}
+}
+
+// retryPolicy return the retry policy of the config
+func retryPolicy(r config.Retry) serve.RetryPolicy {
+	if r.Mode == config.RetryExponential {
+		return serve.NewExponentialBackoff(time.Duration(r.Delay), time.Duration(r.MaxDelay), r.Multiplier, r.Jitter, nil)
+	}
+	return serve.ConstantBackoff(r.Delay)
+}
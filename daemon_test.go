@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yin1999/healthreport/v2/utils/config"
+	"github.com/yin1999/healthreport/v2/utils/notify"
+)
+
+func TestDaemonApply(t *testing.T) {
+	started := make(chan string, 16)
+	run := runWorker
+	defer func() { runWorker = run }()
+	runWorker = func(_ *daemon, ctx context.Context, w *worker) {
+		started <- w.spec.Username
+		if w.spec.Password == "exit" { // the service stopped by itself
+			return
+		}
+		<-ctx.Done()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := newDaemon("", "", "")
+
+	apply := func(accounts ...config.Account) map[string]*worker {
+		t.Helper()
+		d.apply(ctx, accounts, notify.Notifiers{})
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		workers := make(map[string]*worker, len(d.workers))
+		for name, w := range d.workers {
+			workers[name] = w
+		}
+		if len(workers) != len(accounts) {
+			t.Fatalf("expect %d worker(s), got %d", len(accounts), len(workers))
+		}
+		return workers
+	}
+	expectStarted := func(names ...string) {
+		t.Helper()
+		got := make(map[string]bool)
+		for range names {
+			got[<-started] = true
+		}
+		for _, name := range names {
+			if !got[name] {
+				t.Errorf("expect %s started, got %v", name, got)
+			}
+		}
+	}
+
+	first := apply(
+		config.Account{Username: "a", Password: "a"},
+		config.Account{Username: "b", Password: "b"},
+		config.Account{Username: "c", Password: "c"},
+		config.Account{Username: "d", Password: "exit"},
+	)
+	expectStarted("a", "b", "c", "d")
+	<-first["d"].done
+
+	second := apply(
+		config.Account{Username: "a", Password: "a"},       // unchanged
+		config.Account{Username: "b", Password: "changed"}, // changed
+		config.Account{Username: "d", Password: "exit"},    // stopped
+		config.Account{Username: "e", Password: "e"},       // new
+	)
+	expectStarted("b", "d", "e")
+	if second["a"] != first["a"] || !first["a"].running() {
+		t.Error("expect the worker of the unchanged account kept")
+	}
+	for _, name := range []string{"b", "d"} {
+		if second[name] == first[name] {
+			t.Errorf("expect the worker of %s restarted", name)
+		}
+	}
+	for _, name := range []string{"b", "c"} {
+		if first[name].running() {
+			t.Errorf("expect the old worker of %s stopped", name)
+		}
+	}
+	if _, ok := second["c"]; ok {
+		t.Error("expect the worker of the removed account deleted")
+	}
+
+	apply()
+	for name, w := range second {
+		if w.running() {
+			t.Errorf("expect the worker of %s stopped", name)
+		}
+	}
+	select {
+	case name := <-started:
+		t.Errorf("expect no worker started, got %s", name)
+	default:
+	}
+}
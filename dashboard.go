@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	_ "embed"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//go:embed dashboard/index.html
+var dashboardPage []byte
+
+var (
+	// errNoDashboardAuth the dashboard is enabled without any credential
+	errNoDashboardAuth = errors.New("dashboard: a password or a token is required")
+	// errDashboardNoTLS the dashboard address is not a loopback address but TLS is not set,
+	// the credentials would be sent in plain text
+	errDashboardNoTLS = errors.New("dashboard: a certificate and a key are required on a non-loopback address")
+)
+
+// dashboardAuth the credentials of the dashboard, a request is allowed
+// with either the basic auth or the token
+type dashboardAuth struct {
+	user     string
+	password string // empty to disable the basic auth
+	token    string // empty to disable the token auth
+}
+
+// dashboardTLS the certificate and the key files of the dashboard, empty to serve plain http
+type dashboardTLS struct {
+	certFile string
+	keyFile  string
+}
+
+// allow report whether the request carries a valid credential. The token is
+// accepted only in the `Authorization: Bearer` header, not in the URL, which
+// would be kept in the logs and the browser history.
+func (a dashboardAuth) allow(r *http.Request) bool {
+	if a.token != "" {
+		h := r.Header.Get("Authorization")
+		if token := strings.TrimPrefix(h, "Bearer "); token != h && token != "" && equal(token, a.token) {
+			return true
+		}
+	}
+	if a.password != "" {
+		user, password, ok := r.BasicAuth()
+		// evaluate both to keep the time constant
+		userOK, passwordOK := equal(user, a.user), equal(password, a.password)
+		return ok && userOK && passwordOK
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// wrap reject the requests without valid credentials, and the cross-site
+// POST requests since the browser sends the basic auth automatically
+func (a dashboardAuth) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allow(r) {
+			if a.password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="healthreport", charset="UTF-8"`)
+			}
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		if r.Method == http.MethodPost && !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, errors.New("cross-origin request"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin report whether the Origin header, if present, matches the host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// dashboardHandler return the handler of the dashboard page and its api,
+// the api is served under /api without /reload
+func (a *api) dashboardHandler(auth dashboardAuth) http.Handler {
+	mux := http.NewServeMux()
+	var page http.Handler = method(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Write(dashboardPage)
+	})
+	if auth.password != "" {
+		page = auth.wrap(page)
+	}
+	// the page holds no data, it is served without the token since the browser cannot send
+	// the header on navigation, the page reads the token from the URL fragment(#token=)
+	// which is not sent to the server
+	mux.Handle("/", page)
+	mux.Handle("/api/", auth.wrap(http.StripPrefix("/api", a.handler(false))))
+	return mux
+}
+
+// serveDashboard serve the dashboard on addr in the background, over TLS if the
+// certificate is set, which is required on a non-loopback address
+func serveDashboard(addr string, a *api, auth dashboardAuth, t dashboardTLS) (*http.Server, error) {
+	if auth.password == "" && auth.token == "" {
+		return nil, errNoDashboardAuth
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: a.dashboardHandler(auth)}
+	if t.certFile != "" || t.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	} else if !isLoopback(host) {
+		return nil, errDashboardNoTLS
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if srv.TLSConfig != nil {
+		scheme = "https"
+	}
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(l, "", "")
+		} else {
+			err = srv.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			logger.Printf("Dashboard server stopped, err: %s\n", err.Error())
+		}
+	}()
+	logger.Printf("Dashboard listening on %s://%s/\n", scheme, l.Addr())
+	return srv, nil
+}
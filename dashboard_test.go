@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDashboardAuth(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  dashboardAuth
+		set   func(r *http.Request)
+		allow bool
+	}{
+		{"basic auth", dashboardAuth{user: "admin", password: "secret"}, func(r *http.Request) {
+			r.SetBasicAuth("admin", "secret")
+		}, true},
+		{"wrong password", dashboardAuth{user: "admin", password: "secret"}, func(r *http.Request) {
+			r.SetBasicAuth("admin", "wrong")
+		}, false},
+		{"wrong user", dashboardAuth{user: "admin", password: "secret"}, func(r *http.Request) {
+			r.SetBasicAuth("root", "secret")
+		}, false},
+		{"no credential", dashboardAuth{user: "admin", password: "secret", token: "token"}, func(r *http.Request) {}, false},
+		{"basic auth disabled", dashboardAuth{user: "admin", token: "token"}, func(r *http.Request) {
+			r.SetBasicAuth("admin", "")
+		}, false},
+		{"bearer token", dashboardAuth{user: "admin", token: "token"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer token")
+		}, true},
+		{"wrong token", dashboardAuth{user: "admin", token: "token"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer wrong")
+		}, false},
+		{"token query", dashboardAuth{user: "admin", token: "token"}, func(r *http.Request) {
+			r.URL.RawQuery = "token=token"
+		}, false},
+		{"token disabled", dashboardAuth{user: "admin", password: "secret"}, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer ")
+		}, false},
+		{"basic auth with token", dashboardAuth{user: "admin", password: "secret", token: "token"}, func(r *http.Request) {
+			r.SetBasicAuth("admin", "secret")
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			test.set(r)
+			if got := test.auth.allow(r); got != test.allow {
+				t.Errorf("expect allow: %v, got %v", test.allow, got)
+			}
+		})
+	}
+}
+
+func TestDashboardWrap(t *testing.T) {
+	auth := dashboardAuth{user: "admin", password: "secret"}
+	h := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name   string
+		method string
+		login  bool
+		origin string
+		code   int
+	}{
+		{"get", http.MethodGet, true, "", http.StatusOK},
+		{"unauthorized", http.MethodGet, false, "", http.StatusUnauthorized},
+		{"same origin post", http.MethodPost, true, "http://example.com", http.StatusOK},
+		{"cross origin post", http.MethodPost, true, "http://evil.example.com", http.StatusForbidden},
+		{"cross origin get", http.MethodGet, true, "http://evil.example.com", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "http://example.com/api/punch/test", nil)
+			if test.login {
+				r.SetBasicAuth(auth.user, auth.password)
+			}
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != test.code {
+				t.Errorf("expect status %d, got %d", test.code, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expect the basic auth challenge")
+			}
+		})
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	a := &api{d: newDaemon("", "", "")}
+	tests := []struct {
+		name string
+		auth dashboardAuth
+		path string
+		code int
+	}{
+		{"token page", dashboardAuth{token: "token"}, "/", http.StatusOK},
+		{"token api", dashboardAuth{token: "token"}, "/api/status", http.StatusUnauthorized},
+		{"password page", dashboardAuth{user: "admin", password: "secret"}, "/", http.StatusUnauthorized},
+		{"password api", dashboardAuth{user: "admin", password: "secret"}, "/api/status", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			a.dashboardHandler(test.auth).ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.code {
+				t.Errorf("expect status %d, got %d", test.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestServeDashboard(t *testing.T) {
+	cert, key := writeCert(t)
+	auth := dashboardAuth{token: "token"}
+	tests := []struct {
+		name string
+		addr string
+		tls  dashboardTLS
+		err  error
+	}{
+		{"loopback", "127.0.0.1:0", dashboardTLS{}, nil},
+		{"any address", ":0", dashboardTLS{}, errDashboardNoTLS},
+		{"non-loopback", "0.0.0.0:0", dashboardTLS{}, errDashboardNoTLS},
+		{"tls", ":0", dashboardTLS{cert, key}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, err := serveDashboard(test.addr, &api{d: newDaemon("", "", "")}, auth, test.tls)
+			if err != test.err {
+				t.Fatalf("expect %v, got %v", test.err, err)
+			}
+			if srv != nil {
+				srv.Close()
+			}
+		})
+	}
+	if _, err := serveDashboard("127.0.0.1:0", &api{}, dashboardAuth{}, dashboardTLS{}); err != errNoDashboardAuth {
+		t.Errorf("expect %v, got %v", errNoDashboardAuth, err)
+	}
+	if _, err := serveDashboard(":0", &api{}, auth, dashboardTLS{certFile: cert}); err == nil {
+		t.Error("expect an error without the key")
+	}
+}
+
+// writeCert write a self-signed certificate and its key to the temp dir
+func writeCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for name, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err = os.WriteFile(name, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils/history"
+)
+
+// historyCommand list the punch history: healthreport history [flags]
+func historyCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	path := fs.String("history", "history.jsonl", "set punch history `file` path")
+	account := fs.String("account", "", "only list the records of the `username`")
+	from := fs.String("from", "", "only list the records from the `date`(YYYY-MM-DD, China Standard Time)")
+	to := fs.String("to", "", "only list the records until the `date`(YYYY-MM-DD, included)")
+	format := fs.String("format", "table", "set output `format`: table, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := history.Filter{Account: *account}
+	var err error
+	if *from != "" {
+		if filter.From, err = parseDay(*from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if filter.To, err = parseDay(*to); err != nil {
+			return err
+		}
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+
+	records, err := history.New(*path).Query(filter)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "table":
+		return writeHistoryTable(records)
+	case "csv":
+		return history.WriteCSV(os.Stdout, records)
+	case "json":
+		return history.WriteJSON(os.Stdout, records)
+	}
+	return fmt.Errorf("history: unknown format: %s", *format)
+}
+
+// parseDay return the start of the date in China Standard Time
+func parseDay(s string) (time.Time, error) {
+	date, err := serve.ParseDate(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(date.Year, date.Month, date.Day, 0, 0, 0, 0, timeZone), nil
+}
+
+func writeHistoryTable(records []serve.Record) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, "ACCOUNT\tSTART\tDURATION\tATTEMPT\tSTATUS\tKIND\tMESSAGE\n")
+	for _, r := range records {
+		message := r.Message
+		if message == "" {
+			message = r.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%q\n", r.Account,
+			r.Start.In(timeZone).Format("2006-01-02 15:04:05"),
+			r.End.Sub(r.Start).Round(time.Millisecond),
+			r.Attempt, r.Status, r.Kind, message)
+	}
+	return w.Flush()
+}
+
+// runCommand run the subcommand if the first argument is one, and exit
+func runCommand(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	var err error
+	switch args[0] {
+	case "history":
+		err = historyCommand(args[1:])
+	case "notify-queue":
+		err = notifyQueueCommand(args[1:])
+	default:
+		return
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		logger.Fatalln(err.Error())
+	}
+	os.Exit(0)
+}
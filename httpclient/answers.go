@@ -0,0 +1,175 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidAnswer the answer doesn't match the report form
+	ErrInvalidAnswer = errors.New("answers: invalid answer")
+)
+
+// Answers the desired values of the report form, the keys are field names
+// (e.g. "twqk") or the human-readable labels shown on the page (e.g. "体温情况").
+// For selects and radios, the values are the values or the texts of the options,
+// for checkboxes, the values are "true"/"false" (or "是"/"否").
+type Answers map[string]string
+
+// LoadAnswers load answers from a json file
+func LoadAnswers(path string) (Answers, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var a Answers
+	if err = json.NewDecoder(file).Decode(&a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Merge return the answers with the values in override taking precedence
+func (a Answers) Merge(override Answers) Answers {
+	res := make(Answers, len(a)+len(override))
+	for k, v := range a {
+		res[k] = v
+	}
+	for k, v := range override {
+		res[k] = v
+	}
+	return res
+}
+
+// apply set the answers to the form, and return the names of the answered fields
+func (a Answers) apply(form *htmlForm) (names []string, err error) {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields := form.lookup(key)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%w: field %q not found in the form", ErrInvalidAnswer, key)
+		}
+		if err = setAnswer(fields, a[key]); err != nil {
+			return nil, fmt.Errorf("%w: %s(%s): %s", ErrInvalidAnswer, key, fields[0].Name, err.Error())
+		}
+		names = append(names, fields[0].Name)
+	}
+	return
+}
+
+// lookup return the fields with the name, or the field group with the label
+func (f *htmlForm) lookup(key string) []*formField {
+	if fields := f.group(key); len(fields) != 0 {
+		return fields
+	}
+	if key = normalizeLabel(key); key == "" {
+		return nil
+	}
+	for _, field := range f.Fields {
+		if field.Name == "" {
+			continue
+		}
+		if key == normalizeLabel(field.Caption) || key == normalizeLabel(field.Label) && field.Type != "radio" {
+			return f.group(field.Name)
+		}
+	}
+	return nil
+}
+
+// group return the fields with the name
+func (f *htmlForm) group(name string) (fields []*formField) {
+	for _, field := range f.Fields {
+		if field.Name == name {
+			fields = append(fields, field)
+		}
+	}
+	return
+}
+
+// options return the options offered by the field group, nil for free text fields
+func options(fields []*formField) (res []string) {
+	switch fields[0].Type {
+	case "select":
+		for _, o := range fields[0].Options {
+			res = append(res, optionText(o.Value, o.Text))
+		}
+	case "radio":
+		for _, f := range fields {
+			res = append(res, optionText(f.Value, f.Label))
+		}
+	}
+	return
+}
+
+func setAnswer(fields []*formField, value string) error {
+	field := fields[0]
+	switch field.Type {
+	case "select":
+		index := -1
+		for i, o := range field.Options {
+			if o.Value == value || index < 0 && o.Text == value {
+				index = i
+			}
+		}
+		if index < 0 {
+			return fmt.Errorf("%q is not one of the options: %s", value, strings.Join(options(fields), ", "))
+		}
+		for i, o := range field.Options {
+			o.Selected = i == index
+		}
+	case "radio":
+		index := -1
+		for i, f := range fields {
+			if f.Value == value || index < 0 && f.Label == value {
+				index = i
+			}
+		}
+		if index < 0 {
+			return fmt.Errorf("%q is not one of the options: %s", value, strings.Join(options(fields), ", "))
+		}
+		for i, f := range fields {
+			f.Checked = i == index
+		}
+	case "checkbox":
+		switch strings.ToLower(value) {
+		case "true", "1", "on", "是":
+			field.Checked = true
+		case "false", "0", "off", "否":
+			field.Checked = false
+		default:
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+	case "submit", "button", "image", "reset", "file":
+		return fmt.Errorf("cannot answer a %s field", field.Type)
+	default:
+		if field.Disabled {
+			return errors.New("the field is disabled")
+		}
+		field.Value = value
+	}
+	return nil
+}
+
+func optionText(value, text string) string {
+	if text == "" || text == value {
+		return value
+	}
+	return value + "(" + text + ")"
+}
+
+// normalizeLabel remove the spaces, required marks and colons around the label
+func normalizeLabel(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimLeft(s, "*＊ ")
+	s = strings.TrimRight(s, ":： ")
+	return s
+}
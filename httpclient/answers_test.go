@@ -0,0 +1,84 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAnswersApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers Answers
+		expect  map[string][]string // nil value means the field is not submitted
+		err     bool
+	}{
+		{"by name", Answers{"twqk": "2", "sfzx": "是", "xm": "王五", "bz": ""},
+			map[string][]string{"twqk": {"2"}, "sfzx": {"是"}, "xm": {"王五"}, "bz": {""}}, false},
+		{"by option text", Answers{"twqk": "异常", "jkmys": "黄色"},
+			map[string][]string{"twqk": {"2"}, "jkmys": {"黄色"}}, false},
+		{"by label", Answers{"体温情况": "正常(37.3℃以下)", "健康码颜色": "红色", "是否在校": "否"},
+			map[string][]string{"twqk": {"1"}, "jkmys": {"红色"}, "sfzx": {"否"}}, false},
+		{"checkbox", Answers{"本人承诺": "false", "ck_other": "是"},
+			map[string][]string{"ck_brcnnrss": nil, "ck_other": {"1"}}, false},
+		{"invalid option", Answers{"twqk": "37.0"}, nil, true},
+		{"invalid radio", Answers{"是否在校": "不确定"}, nil, true},
+		{"invalid boolean", Answers{"ck_other": "maybe"}, nil, true},
+		{"unknown field", Answers{"体温": "正常"}, nil, true},
+		{"disabled field", Answers{"disabled": "y"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form, err := findForm(parseFixture(t, "report.html"), "__VIEWSTATE")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tt.answers.apply(form)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidAnswer) {
+					t.Fatalf("expect %v, got %v", ErrInvalidAnswer, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := form.values()
+			for key, expect := range tt.expect {
+				if got := values[key]; len(got) != len(expect) || len(got) != 0 && got[0] != expect[0] {
+					t.Errorf("%s: expect %v, got %v", key, expect, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPunchWithAnswers(t *testing.T) {
+	p := newTestPortal(t, false)
+	account := &Account{
+		Username: testUsername,
+		Password: testPassword,
+		Answers:  Answers{"体温情况": "异常", "sfzx": "否", "jkmys": "黄色"},
+	}
+	if err := Punch(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	posts := p.Posts()
+	if len(posts) != 1 {
+		t.Fatalf("expect 1 report saved, got %d", len(posts))
+	}
+	for key, expect := range map[string]string{"twqk": "2", "sfzx": "否", "jkmys": "黄色", "xh": testUsername} {
+		if got := posts[0].Get(key); got != expect {
+			t.Errorf("%s: expect %q, got %q", key, expect, got)
+		}
+	}
+
+	// the answers are validated against the options on the page
+	account.Answers = Answers{"jkmys": "蓝色"}
+	if err := Punch(context.Background(), account); !errors.Is(err, ErrInvalidAnswer) {
+		t.Fatalf("expect %v, got %v", ErrInvalidAnswer, err)
+	}
+	if n := len(p.Posts()); n != 1 {
+		t.Fatalf("expect no more report saved, got %d", n)
+	}
+}
@@ -3,7 +3,6 @@ package httpclient
 import (
 	"context"
 	"net/http"
-	"net/url"
 	"time"
 )
 
@@ -11,45 +10,42 @@ import (
 func LoginConfirm(ctx context.Context, account interface{}) error {
 	c := newClient(ctx)
 	err := c.login(account.(*Account))
-	return parseURLError(err)
+	return classify(err)
 }
 
 // Punch 打卡
 func Punch(ctx context.Context, account interface{}) (err error) {
 	defer func() {
-		err = parseURLError(err)
+		err = classify(err)
 	}()
 
+	a := account.(*Account)
 	c := newClient(ctx)
-	err = c.login(account.(*Account)) // 登录，获取cookie
+	err = c.login(a) // 登录，获取cookie
 	if err != nil {
 		return
 	}
 
-	var form url.Values
-	form, err = c.getFormDetail() // 获取打卡列表信息
-	if err != nil {
-		return
-	}
-
-	err = c.postForm(form) // 提交表单
+	err = c.report(a) // 获取打卡表单并提交
 	return
 }
 
 func newClient(ctx context.Context) *punchClient {
-	return &punchClient{
-		ctx: ctx,
-		httpClient: &http.Client{
-			Jar:     newCookieJar(),
-			Timeout: time.Duration(10 * time.Second),
-		},
-	}
+	return newClientWithJar(ctx, newCookieJar())
 }
 
-// parseURLError 解析URL错误
-func parseURLError(err error) error {
-	if v, ok := err.(*url.Error); ok {
-		err = v.Err
+func newClientWithJar(ctx context.Context, jar *cookieJar) *punchClient {
+	p := currentPortal()
+	c := &punchClient{
+		ctx:    ctx,
+		jar:    jar,
+		portal: p.Portal,
+	}
+	c.httpClient = &http.Client{
+		Transport:     p.transport,
+		CheckRedirect: c.checkRedirect,
+		Jar:           jar,
+		Timeout:       time.Duration(10 * time.Second),
 	}
-	return err
+	return c
 }
@@ -0,0 +1,140 @@
+package httpclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/yin1999/healthreport/v2/httpclient/portaltest"
+)
+
+const (
+	testUsername = "1906010101"
+	testPassword = "Passw0rd"
+)
+
+// newTestPortal start a fake portal and point the client at it,
+// the captcha is recognized correctly unless wrongCaptcha is set
+func newTestPortal(t *testing.T, wrongCaptcha bool) *portaltest.Portal {
+	t.Helper()
+	p := portaltest.NewPortal(testUsername, testPassword)
+	usePortal(t, p, wrongCaptcha)
+	if err := SetPortal(Portal{BaseURL: p.URL}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// usePortal replace the captcha recognizer and restore the portal settings after the test
+func usePortal(t *testing.T, p *portaltest.Portal, wrongCaptcha bool) {
+	t.Helper()
+	oldPortal, oldRecognize, oldWait := currentPortal(), recognize, retryWait
+	recognize = func([]byte) (string, error) {
+		if wrongCaptcha {
+			return "0000", nil
+		}
+		return p.Captcha(), nil
+	}
+	retryWait = 0
+	t.Cleanup(func() {
+		p.Close()
+		portal, recognize, retryWait = oldPortal, oldRecognize, oldWait
+	})
+}
+
+func TestLoginConfirm(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		wrongCaptcha bool
+		err          string
+	}{
+		{"success", testPassword, false, ""},
+		{"wrong captcha", testPassword, true, ErrWrongCaptcha.Error()},
+		{"wrong password", "wrong", false, portaltest.MsgWrongPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestPortal(t, tt.wrongCaptcha)
+			err := LoginConfirm(context.Background(), &Account{Username: testUsername, Password: tt.password})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expect error containing %q, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestPunch(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		wrongCaptcha bool
+		incomplete   bool
+		err          string
+	}{
+		{"success", testPassword, false, false, ""},
+		{"wrong captcha", testPassword, true, false, ErrWrongCaptcha.Error()},
+		{"wrong password", "wrong", false, false, portaltest.MsgWrongPassword},
+		{"incomplete form", testPassword, false, true, ErrIncompleteForm.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPortal(t, tt.wrongCaptcha)
+			p.Incomplete = tt.incomplete
+			err := Punch(context.Background(), &Account{Username: testUsername, Password: tt.password})
+			posts := p.Posts()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error containing %q, got: %v", tt.err, err)
+				}
+				if len(posts) != 0 {
+					t.Fatalf("expect no report saved, got %d", len(posts))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(posts) != 1 {
+				t.Fatalf("expect 1 report saved, got %d", len(posts))
+			}
+			form := posts[0]
+			if v := form.Get("__EVENTTARGET"); v != "databc" {
+				t.Errorf("__EVENTTARGET: expect %q, got %q", "databc", v)
+			}
+			for _, key := range []string{"xh", "twqk", "sfzx", "jkmys", "__VIEWSTATE"} {
+				if v := form.Get(key); v != p.Field(key) {
+					t.Errorf("%s: expect %q, got %q", key, p.Field(key), v)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptchaMetrics(t *testing.T) {
+	p := newTestPortal(t, false)
+	calls := 0
+	recognize = func([]byte) (string, error) {
+		calls++
+		if calls == 1 { // a code of wrong length
+			return "12", nil
+		}
+		return p.Captcha(), nil
+	}
+	attempts, failures := captchaRecognitions.With().Value(), captchaFailures.With().Value()
+	if err := LoginConfirm(context.Background(), &Account{Username: testUsername, Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	if got := captchaRecognitions.With().Value() - attempts; got != 2 {
+		t.Errorf("expect 2 recognitions, got %v", got)
+	}
+	if got := captchaFailures.With().Value() - failures; got != 1 {
+		t.Errorf("expect 1 failure, got %v", got)
+	}
+}
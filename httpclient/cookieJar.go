@@ -1,42 +1,260 @@
 package httpclient
 
 import (
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
-type cookieJar []*http.Cookie
+var errIllegalDomain = errors.New("cookiejar: illegal cookie domain attribute")
+
+// cookieJar a RFC 6265 compliant cookie jar without public suffix list,
+// it can be serialized to json with all the cookies (including session cookies)
+type cookieJar struct {
+	mux     sync.Mutex
+	entries map[string]*cookieEntry // key: domain;path;name
+	now     func() time.Time
+}
+
+// cookieEntry the stored cookie
+type cookieEntry struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Domain     string    `json:"domain"`
+	Path       string    `json:"path"`
+	HostOnly   bool      `json:"hostOnly,omitempty"`
+	Secure     bool      `json:"secure,omitempty"`
+	HttpOnly   bool      `json:"httpOnly,omitempty"`
+	Persistent bool      `json:"persistent,omitempty"`
+	Expires    time.Time `json:"expires,omitempty"` // only valid when Persistent is true
+	Creation   time.Time `json:"creation"`
+}
+
+func (e *cookieEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+// domainMatch implement "domain-match" of RFC 6265 section 5.1.3
+func (e *cookieEntry) domainMatch(host string) bool {
+	if e.Domain == host {
+		return true
+	}
+	return !e.HostOnly && strings.HasSuffix(host, "."+e.Domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch implement "path-match" of RFC 6265 section 5.1.4
+func (e *cookieEntry) pathMatch(path string) bool {
+	if path == e.Path {
+		return true
+	}
+	if strings.HasPrefix(path, e.Path) {
+		return e.Path[len(e.Path)-1] == '/' || path[len(e.Path)] == '/'
+	}
+	return false
+}
 
 var _ http.CookieJar = &cookieJar{} // implement http.CookieJar
 
 // newCookieJar return a cookiejar
 func newCookieJar() *cookieJar {
-	return &cookieJar{}
+	return &cookieJar{
+		entries: make(map[string]*cookieEntry),
+		now:     time.Now,
+	}
 }
 
-// SetCookies set cookies to cookie storage
-func (cookies *cookieJar) SetCookies(u *url.URL, newCookies []*http.Cookie) {
-	now := time.Now()
-	for _, cookie := range newCookies {
-		if cookie.Expires.IsZero() != cookie.Expires.Before(now) || cookie.MaxAge < 0 { // cookie is expired
+// SetCookies set cookies to cookie storage, cookies with the same name,
+// domain and path are replaced, and expired cookies are deleted
+func (jar *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	host := canonicalHost(u)
+	now := jar.now()
+
+	jar.mux.Lock()
+	defer jar.mux.Unlock()
+	for _, cookie := range cookies {
+		e := &cookieEntry{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+			Creation: now,
+		}
+		var err error
+		if e.Domain, e.HostOnly, err = domainAndType(host, cookie.Domain); err != nil {
+			continue
+		}
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = defaultPath(u.Path)
+		}
+
+		remove := false
+		switch {
+		case cookie.MaxAge < 0: // "Max-Age: 0" or "Max-Age" less than 0
+			remove = true
+		case cookie.MaxAge > 0:
+			e.Persistent = true
+			e.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			e.Persistent = true
+			e.Expires = cookie.Expires
+			remove = !e.Expires.After(now)
+		}
+
+		id := e.id()
+		if remove {
+			delete(jar.entries, id)
 			continue
 		}
-		if cookie.Domain == "" { // if cookie.Domain is empty, using host instead
-			cookie.Domain = u.Hostname()
+		if old, ok := jar.entries[id]; ok {
+			e.Creation = old.Creation
 		}
-		*cookies = append(*cookies, cookie)
+		jar.entries[id] = e
 	}
 }
 
-// Cookies get cookie by domains
-func (cookies cookieJar) Cookies(u *url.URL) (res []*http.Cookie) {
-	domain := u.Hostname()
-	for _, cookie := range cookies {
-		if strings.HasSuffix(domain, cookie.Domain) {
-			res = append(res, cookie)
+// Cookies return the cookies to send in a request for the url
+func (jar *cookieJar) Cookies(u *url.URL) (res []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	host := canonicalHost(u)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	secure := u.Scheme == "https"
+	now := jar.now()
+
+	jar.mux.Lock()
+	defer jar.mux.Unlock()
+	selected := make([]*cookieEntry, 0, len(jar.entries))
+	for id, e := range jar.entries {
+		if e.Persistent && !e.Expires.After(now) {
+			delete(jar.entries, id)
+			continue
+		}
+		if !e.domainMatch(host) || !e.pathMatch(path) || e.Secure && !secure {
+			continue
+		}
+		selected = append(selected, e)
+	}
+
+	// cookies with longer paths are listed before cookies with shorter paths,
+	// cookies with the same path length are ordered by creation time
+	sort.Slice(selected, func(i, j int) bool {
+		a, b := selected[i], selected[j]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) > len(b.Path)
+		}
+		if !a.Creation.Equal(b.Creation) {
+			return a.Creation.Before(b.Creation)
+		}
+		return a.id() < b.id()
+	})
+	for _, e := range selected {
+		res = append(res, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+	return
+}
+
+// len return the number of cookies which are not expired
+func (jar *cookieJar) len() (n int) {
+	now := jar.now()
+	jar.mux.Lock()
+	defer jar.mux.Unlock()
+	for _, e := range jar.entries {
+		if !e.Persistent || e.Expires.After(now) {
+			n++
 		}
 	}
 	return
 }
+
+// MarshalJSON implement json.Marshaler
+func (jar *cookieJar) MarshalJSON() ([]byte, error) {
+	jar.mux.Lock()
+	entries := make([]*cookieEntry, 0, len(jar.entries))
+	for _, e := range jar.entries {
+		entries = append(entries, e)
+	}
+	jar.mux.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id() < entries[j].id()
+	})
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON implement json.Unmarshaler, the cookies are replaced, the cookies
+// without a domain are dropped and the path defaults to "/" as the file may be edited
+func (jar *cookieJar) UnmarshalJSON(data []byte) error {
+	var entries []*cookieEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	jar.mux.Lock()
+	defer jar.mux.Unlock()
+	jar.entries = make(map[string]*cookieEntry, len(entries))
+	for _, e := range entries {
+		if e == nil || e.Domain == "" {
+			continue
+		}
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = "/"
+		}
+		jar.entries[e.id()] = e
+	}
+	return nil
+}
+
+func canonicalHost(u *url.URL) string {
+	return strings.ToLower(u.Hostname())
+}
+
+// domainAndType return the domain of the cookie and whether it is host-only,
+// see RFC 6265 section 5.3 step 4 to 6
+func domainAndType(host, domain string) (string, bool, error) {
+	if domain == "" {
+		return host, true, nil
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if net.ParseIP(host) != nil {
+		// an IP address only domain-matches itself
+		if domain != host {
+			return "", false, errIllegalDomain
+		}
+		return host, true, nil
+	}
+	if domain == "" || domain[len(domain)-1] == '.' {
+		return "", false, errIllegalDomain
+	}
+	if domain == host {
+		return domain, false, nil
+	}
+	// reject top level domains, as no public suffix list is used
+	if !strings.Contains(domain, ".") || !strings.HasSuffix(host, "."+domain) {
+		return "", false, errIllegalDomain
+	}
+	return domain, false, nil
+}
+
+// defaultPath implement "default-path" of RFC 6265 section 5.1.4
+func defaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndexByte(path, '/')
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
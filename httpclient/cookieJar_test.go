@@ -0,0 +1,161 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jarTestTime = time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+
+// jarAction set cookies from setURL (skipped when empty), move the clock
+// forward, and then check the cookies sent to queryURL
+type jarAction struct {
+	setURL   string
+	cookies  []string // Set-Cookie header values
+	advance  time.Duration
+	queryURL string
+	expect   string // cookies in "name=value" format, joined by "; "
+}
+
+func TestCookieJar(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []jarAction
+	}{
+		{"host-only", []jarAction{
+			{"http://smst.hhu.edu.cn/", []string{"a=1"}, 0, "http://smst.hhu.edu.cn/", "a=1"},
+			{"", nil, 0, "http://www.smst.hhu.edu.cn/", ""},
+			{"", nil, 0, "http://hhu.edu.cn/", ""},
+		}},
+		{"domain", []jarAction{
+			{"http://smst.hhu.edu.cn/", []string{"a=1; Domain=hhu.edu.cn"}, 0, "http://www.hhu.edu.cn/", "a=1"},
+			{"", nil, 0, "http://hhu.edu.cn/", "a=1"},
+			{"", nil, 0, "http://evilhhu.edu.cn/", ""},
+			{"", nil, 0, "http://hhu.edu.cn.evil.com/", ""},
+		}},
+		{"illegal domain", []jarAction{
+			{"http://smst.hhu.edu.cn/", []string{"a=1; Domain=evil.com", "b=2; Domain=cn", "c=3; Domain=www.smst.hhu.edu.cn"}, 0, "http://smst.hhu.edu.cn/", ""},
+			{"", nil, 0, "http://www.evil.com/", ""},
+			{"http://127.0.0.1/", []string{"d=4; Domain=0.0.1"}, 0, "http://127.0.0.1/", ""},
+		}},
+		{"replace", []jarAction{
+			{"http://smst.hhu.edu.cn/", []string{"a=1", "b=2"}, time.Second, "http://smst.hhu.edu.cn/", "a=1; b=2"},
+			{"http://smst.hhu.edu.cn/", []string{"a=3"}, 0, "http://smst.hhu.edu.cn/", "a=3; b=2"},
+		}},
+		{"delete", []jarAction{
+			{"http://smst.hhu.edu.cn/", []string{"a=1", "b=2", "c=3"}, 0, "http://smst.hhu.edu.cn/", "a=1; b=2; c=3"},
+			{"http://smst.hhu.edu.cn/", []string{"a=; Max-Age=0", "b=; Expires=Thu, 01 Jan 1970 00:00:00 GMT"}, 0, "http://smst.hhu.edu.cn/", "c=3"},
+			{"http://smst.hhu.edu.cn/", []string{"c=; Max-Age=0; Path=/other"}, 0, "http://smst.hhu.edu.cn/", "c=3"},
+		}},
+		{"expire at lookup", []jarAction{
+			{"http://smst.hhu.edu.cn/", []string{"a=1; Max-Age=60", "b=2; Expires=Sun, 01 May 2022 09:00:00 GMT", "c=3"}, 59 * time.Second, "http://smst.hhu.edu.cn/", "a=1; b=2; c=3"},
+			{"", nil, time.Second, "http://smst.hhu.edu.cn/", "b=2; c=3"},
+			{"", nil, time.Hour, "http://smst.hhu.edu.cn/", "c=3"},
+		}},
+		{"path", []jarAction{
+			{"http://smst.hhu.edu.cn/Mobile/rsbulid/r.aspx", []string{"a=1", "b=2; Path=/Mobile", "c=3; Path=/"}, 0, "http://smst.hhu.edu.cn/Mobile/rsbulid/x.aspx", "a=1; b=2; c=3"},
+			{"", nil, 0, "http://smst.hhu.edu.cn/Mobile/r.aspx", "b=2; c=3"},
+			{"", nil, 0, "http://smst.hhu.edu.cn/Mobile", "b=2; c=3"},
+			{"", nil, 0, "http://smst.hhu.edu.cn/MobileX", "c=3"},
+			{"", nil, 0, "http://smst.hhu.edu.cn", "c=3"},
+		}},
+		{"secure", []jarAction{
+			{"https://smst.hhu.edu.cn/", []string{"a=1; Secure", "b=2; HttpOnly"}, 0, "https://smst.hhu.edu.cn/", "a=1; b=2"},
+			{"", nil, 0, "http://smst.hhu.edu.cn/", "b=2"},
+		}},
+		{"case insensitive host", []jarAction{
+			{"http://SMST.hhu.edu.cn/", []string{"a=1; Domain=HHU.edu.cn"}, 0, "http://smst.HHU.edu.cn/", "a=1"},
+		}},
+		{"non http", []jarAction{
+			{"ftp://smst.hhu.edu.cn/", []string{"a=1"}, 0, "ftp://smst.hhu.edu.cn/", ""},
+			{"", nil, 0, "http://smst.hhu.edu.cn/", ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := jarTestTime
+			jar := newCookieJar()
+			jar.now = func() time.Time { return now }
+			for i, action := range tt.actions {
+				if action.setURL != "" {
+					jar.SetCookies(mustParseURL(t, action.setURL), parseSetCookies(action.cookies))
+				}
+				now = now.Add(action.advance)
+				if got := joinCookies(jar.Cookies(mustParseURL(t, action.queryURL))); got != action.expect {
+					t.Errorf("step #%d: expect %q, got %q", i+1, action.expect, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCookieJarSerialization(t *testing.T) {
+	now := jarTestTime
+	jar := newCookieJar()
+	jar.now = func() time.Time { return now }
+	u := mustParseURL(t, "https://smst.hhu.edu.cn/Mobile/")
+	jar.SetCookies(u, parseSetCookies([]string{"ASP.NET_SessionId=abc; HttpOnly", "a=1; Max-Age=60; Secure", "b=2; Domain=hhu.edu.cn; Path=/"}))
+	if n := jar.len(); n != 3 {
+		t.Fatalf("expect 3 cookies, got %d", n)
+	}
+
+	data, err := json.Marshal(jar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newCookieJar()
+	restored.now = jar.now
+	if err = json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	expect := joinCookies(jar.Cookies(u))
+	if got := joinCookies(restored.Cookies(u)); got != expect {
+		t.Fatalf("expect %q, got %q", expect, got)
+	}
+
+	now = now.Add(time.Minute)
+	if n := restored.len(); n != 2 {
+		t.Fatalf("expect 2 cookies after expiry, got %d", n)
+	}
+	if got := joinCookies(restored.Cookies(mustParseURL(t, "http://www.hhu.edu.cn/"))); got != "b=2" {
+		t.Fatalf("expect %q, got %q", "b=2", got)
+	}
+}
+
+func TestCookieJarInvalidEntries(t *testing.T) {
+	jar := newCookieJar()
+	jar.now = func() time.Time { return jarTestTime }
+	data := `[null, {"name":"a","value":"1"}, {"name":"b","value":"2","domain":"smst.hhu.edu.cn","hostOnly":true}]`
+	if err := json.Unmarshal([]byte(data), jar); err != nil {
+		t.Fatal(err)
+	}
+	if got := joinCookies(jar.Cookies(mustParseURL(t, "https://smst.hhu.edu.cn/Mobile/"))); got != "b=2" {
+		t.Fatalf("expect %q, got %q", "b=2", got)
+	}
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func parseSetCookies(lines []string) []*http.Cookie {
+	res := &http.Response{Header: http.Header{"Set-Cookie": lines}}
+	return res.Cookies()
+}
+
+func joinCookies(cookies []*http.Cookie) string {
+	s := make([]string, len(cookies))
+	for i, c := range cookies {
+		s[i] = c.Name + "=" + c.Value
+	}
+	return strings.Join(s, "; ")
+}
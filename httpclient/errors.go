@@ -0,0 +1,163 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Kind the kind of a punch error
+type Kind uint8
+
+const (
+	// KindUnknown the error is not classified
+	KindUnknown Kind = iota
+	// KindAuth the portal rejects the username or password
+	KindAuth
+	// KindCaptcha the captcha is wrong or cannot be recognized
+	KindCaptcha
+	// KindPortal the forms or fields of the portal are missing, the portal may be changed
+	KindPortal
+	// KindNetwork the request failed due to network errors or timeout
+	KindNetwork
+	// KindServer the portal responds with a 5xx status, a throttling status(403, 408, 429)
+	// or an empty page, it may be busy
+	KindServer
+	// KindValidation the portal rejects the report form, or the answers don't match the form
+	KindValidation
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth rejected"
+	case KindCaptcha:
+		return "captcha"
+	case KindPortal:
+		return "portal changed"
+	case KindNetwork:
+		return "network"
+	case KindServer:
+		return "server error"
+	case KindValidation:
+		return "validation"
+	default:
+		return "unknown"
+	}
+}
+
+// Retryable report whether retrying later may succeed
+func (k Kind) Retryable() bool {
+	switch k {
+	case KindAuth, KindPortal, KindValidation:
+		return false
+	default:
+		return true
+	}
+}
+
+// Error the error returned by LoginConfirm, Punch and Preview
+type Error struct {
+	Kind    Kind
+	Op      string // the operation, e.g. "login", empty if unknown
+	Message string // the message from the portal(e.g. the `cw` field or the http status), if any
+	Err     error  // the underlying error, if any
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, 0, 3)
+	for _, s := range [...]string{e.Op, e.Message} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if e.Err != nil {
+		parts = append(parts, e.Err.Error())
+	}
+	if len(parts) == 0 {
+		parts = append(parts, e.Kind.String()+" error")
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable report whether retrying later may succeed
+func (e *Error) Retryable() bool {
+	return e.Kind.Retryable()
+}
+
+// ErrorKind return the name of the kind
+func (e *Error) ErrorKind() string {
+	return e.Kind.String()
+}
+
+// ServerMessage return the message from the portal
+func (e *Error) ServerMessage() string {
+	return e.Message
+}
+
+// KindOf return the kind of the error, KindUnknown if the error is not an *Error
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindUnknown
+}
+
+// checkStatus return an error if the status of the response is not 200 OK
+func checkStatus(op string, res *http.Response) error {
+	switch code := res.StatusCode; {
+	case code == http.StatusOK:
+		return nil
+	case code >= 500, code == http.StatusForbidden, code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return &Error{Kind: KindServer, Op: op, Message: res.Status}
+	default:
+		return &Error{Kind: KindPortal, Op: op, Message: res.Status}
+	}
+}
+
+// classify wrap the error into *Error with its kind, nil, context.Canceled
+// and ErrAlreadyReported are returned as they are
+func classify(err error) error {
+	err = parseURLError(err)
+	if err == nil || err == context.Canceled || err == ErrAlreadyReported {
+		return err
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	kind := KindUnknown
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrWrongCaptcha), errors.Is(err, ErrCannotRecognizeCaptcha):
+		kind = KindCaptcha
+	case errors.Is(err, ErrIncompleteForm), errors.Is(err, ErrInvalidAnswer):
+		kind = KindValidation
+	case errors.Is(err, ErrFormNotFound):
+		kind = KindPortal
+	case errors.Is(err, ErrEmptyResponse):
+		kind = KindServer
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		kind = KindNetwork
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// parseURLError 解析URL错误
+func parseURLError(err error) error {
+	if v, ok := err.(*url.Error); ok {
+		err = v.Err
+	}
+	return err
+}
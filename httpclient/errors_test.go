@@ -0,0 +1,66 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/yin1999/healthreport/v2/httpclient/portaltest"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(p *testPortal, a *Account)
+		kind      Kind
+		retryable bool
+		target    error
+	}{
+		{"wrong password", func(p *testPortal, a *Account) { a.Password = "wrong" }, KindAuth, false, nil},
+		{"unknown login message", func(p *testPortal, a *Account) { p.LoginMessage = "系统繁忙，请稍后再试!" }, KindUnknown, true, nil},
+		{"wrong captcha", func(p *testPortal, a *Account) { p.wrongCaptcha = true }, KindCaptcha, true, ErrWrongCaptcha},
+		{"incomplete form", func(p *testPortal, a *Account) { p.Incomplete = true }, KindValidation, false, ErrIncompleteForm},
+		{"invalid answer", func(p *testPortal, a *Account) { a.Answers = Answers{"twqk": "3"} }, KindValidation, false, ErrInvalidAnswer},
+		{"server error", func(p *testPortal, a *Account) { p.FailStatus = http.StatusServiceUnavailable }, KindServer, true, nil},
+		{"throttled", func(p *testPortal, a *Account) { p.FailStatus = http.StatusTooManyRequests }, KindServer, true, nil},
+		{"request timeout", func(p *testPortal, a *Account) { p.FailStatus = http.StatusRequestTimeout }, KindServer, true, nil},
+		{"blocked by waf", func(p *testPortal, a *Account) { p.FailStatus = http.StatusForbidden }, KindServer, true, nil},
+		{"empty page", func(p *testPortal, a *Account) { p.Blank = true }, KindServer, true, ErrEmptyResponse},
+		{"portal changed", func(p *testPortal, a *Account) { p.FailStatus = http.StatusNotFound }, KindPortal, false, nil},
+		{"network", func(p *testPortal, a *Account) { p.Close() }, KindNetwork, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testPortal{Portal: newTestPortal(t, false)}
+			recognize = func([]byte) (string, error) {
+				if p.wrongCaptcha {
+					return "0000", nil
+				}
+				return p.Captcha(), nil
+			}
+			account := &Account{Username: testUsername, Password: testPassword}
+			tt.setup(p, account)
+
+			err := Punch(context.Background(), account)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expect *Error, got %T: %v", err, err)
+			}
+			if e.Kind != tt.kind || KindOf(err) != tt.kind {
+				t.Errorf("expect kind %v, got %v (err: %v)", tt.kind, e.Kind, err)
+			}
+			if e.Retryable() != tt.retryable {
+				t.Errorf("expect retryable: %v, got %v", tt.retryable, e.Retryable())
+			}
+			if tt.target != nil && !errors.Is(err, tt.target) {
+				t.Errorf("expect %v in the chain of %v", tt.target, err)
+			}
+		})
+	}
+}
+
+type testPortal struct {
+	*portaltest.Portal
+	wrongCaptcha bool
+}
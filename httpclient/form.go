@@ -0,0 +1,446 @@
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrFormNotFound the expected form is not found in the page
+	ErrFormNotFound = errors.New("form: form not found")
+	// ErrEmptyResponse the page is empty, the portal may be busy
+	ErrEmptyResponse = errors.New("form: empty response")
+)
+
+// htmlForm a form extracted from a html page
+type htmlForm struct {
+	Name   string
+	ID     string
+	Action string
+	Method string
+	Fields []*formField // in document order
+}
+
+// formField a form control: input, select or textarea
+type formField struct {
+	Name     string
+	ID       string
+	Type     string // type of the input, "select" or "textarea"
+	Value    string // value of the input or the content of the textarea
+	Checked  bool   // whether the checkbox or radio is checked
+	Disabled bool
+	Multiple bool           // multiple select
+	Options  []*fieldOption // options of the select
+	Label    string         // text of the <label> for the field
+	Caption  string         // the nearest text before the field, e.g. text in the previous table cell
+}
+
+// fieldOption an option of a select
+type fieldOption struct {
+	Value    string
+	Text     string
+	Selected bool
+
+	hasValue bool // whether the value attribute is present
+}
+
+// field return the first field with the name, nil if not found
+func (f *htmlForm) field(name string) *formField {
+	for _, field := range f.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// values return the values of the successful controls, as a browser submits the form
+func (f *htmlForm) values() url.Values {
+	v := make(url.Values, len(f.Fields))
+	for _, field := range f.Fields {
+		if field.Name == "" || field.Disabled {
+			continue
+		}
+		switch field.Type {
+		case "submit", "button", "image", "reset", "file":
+			// only submitted when activated
+		case "checkbox", "radio":
+			if field.Checked {
+				value := field.Value
+				if value == "" {
+					value = "on"
+				}
+				v.Add(field.Name, value)
+			}
+		case "select":
+			selected := false
+			for _, o := range field.Options {
+				if o.Selected {
+					v.Add(field.Name, o.Value)
+					selected = true
+				}
+			}
+			if !selected && !field.Multiple && len(field.Options) != 0 {
+				v.Add(field.Name, field.Options[0].Value) // the first option is selected by default
+			}
+		default:
+			v.Add(field.Name, field.Value)
+		}
+	}
+	return v
+}
+
+// fieldValue return the value of the first field with the name in the forms
+func fieldValue(forms []*htmlForm, name string) (string, bool) {
+	for _, f := range forms {
+		if field := f.field(name); field != nil {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// findForm return the first form containing the field
+func findForm(forms []*htmlForm, field string) (*htmlForm, error) {
+	for _, f := range forms {
+		if f.field(field) != nil {
+			return f, nil
+		}
+	}
+	return nil, ErrFormNotFound
+}
+
+// parseForms extract all the forms from a html page, the controls
+// outside of any form are collected in an extra form at the end
+func parseForms(r io.Reader) ([]*htmlForm, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrEmptyResponse
+	}
+	var (
+		forms    []*htmlForm
+		orphan   = &htmlForm{}
+		current  *htmlForm
+		selectF  *formField
+		option   *fieldOption
+		text     strings.Builder
+		caption  string                // the last text not consumed by a field
+		labelFor string                // id of the field for the current <label>
+		labels   = map[string]string{} // id -> label
+		label    strings.Builder
+		endOfOpt = func() {
+			if option == nil {
+				return
+			}
+			option.Text = strings.TrimSpace(text.String())
+			if !option.hasValue {
+				option.Value = option.Text
+			}
+			option = nil
+		}
+		add = func(field *formField) {
+			field.Caption, caption = caption, ""
+			if current != nil {
+				current.Fields = append(current.Fields, field)
+			} else {
+				orphan.Fields = append(orphan.Fields, field)
+			}
+		}
+	)
+
+	t := &tokenizer{data: data}
+	for {
+		tok, ok := t.next()
+		if !ok {
+			break
+		}
+		switch tok.kind {
+		case textToken:
+			if option != nil {
+				text.WriteString(tok.data)
+				break
+			}
+			if labelFor != "" {
+				label.WriteString(tok.data)
+			}
+			if v := strings.TrimSpace(tok.data); v != "" {
+				caption = v
+			}
+		case startTagToken:
+			switch tok.name {
+			case "form":
+				current = &htmlForm{
+					Name:   tok.attr("name"),
+					ID:     tok.attr("id"),
+					Action: tok.attr("action"),
+					Method: strings.ToLower(tok.attr("method")),
+				}
+				forms = append(forms, current)
+			case "input":
+				typ := strings.ToLower(tok.attr("type"))
+				if typ == "" {
+					typ = "text"
+				}
+				add(&formField{
+					Name:     tok.attr("name"),
+					ID:       tok.attr("id"),
+					Type:     typ,
+					Value:    tok.attr("value"),
+					Checked:  tok.hasAttr("checked"),
+					Disabled: tok.hasAttr("disabled"),
+				})
+			case "select":
+				endOfOpt()
+				selectF = &formField{
+					Name:     tok.attr("name"),
+					ID:       tok.attr("id"),
+					Type:     "select",
+					Disabled: tok.hasAttr("disabled"),
+					Multiple: tok.hasAttr("multiple"),
+				}
+				add(selectF)
+			case "option":
+				endOfOpt()
+				if selectF == nil {
+					break
+				}
+				option = &fieldOption{
+					Value:    tok.attr("value"),
+					Selected: tok.hasAttr("selected"),
+					hasValue: tok.hasAttr("value"),
+				}
+				selectF.Options = append(selectF.Options, option)
+				text.Reset()
+			case "optgroup":
+				endOfOpt()
+			case "textarea":
+				add(&formField{
+					Name:     tok.attr("name"),
+					ID:       tok.attr("id"),
+					Type:     "textarea",
+					Value:    strings.TrimPrefix(html.UnescapeString(t.rawText("textarea")), "\n"),
+					Disabled: tok.hasAttr("disabled"),
+				})
+			case "label":
+				labelFor = tok.attr("for")
+				label.Reset()
+			case "script", "style":
+				t.rawText(tok.name)
+			}
+		case endTagToken:
+			switch tok.name {
+			case "form":
+				current = nil
+			case "option", "optgroup":
+				endOfOpt()
+			case "select":
+				endOfOpt()
+				selectF = nil
+			case "label":
+				if labelFor != "" {
+					labels[labelFor] = strings.TrimSpace(label.String())
+					labelFor = ""
+				}
+			}
+		}
+	}
+	endOfOpt()
+	for _, f := range append(forms, orphan) {
+		for _, field := range f.Fields {
+			if field.ID != "" {
+				field.Label = labels[field.ID]
+			}
+		}
+	}
+	if len(orphan.Fields) != 0 {
+		forms = append(forms, orphan)
+	}
+	return forms, nil
+}
+
+type tokenKind uint8
+
+const (
+	textToken tokenKind = iota
+	startTagToken
+	endTagToken
+)
+
+type token struct {
+	kind  tokenKind
+	name  string // lower case tag name
+	data  string // unescaped text
+	attrs [][2]string
+}
+
+func (t *token) attr(name string) string {
+	for _, a := range t.attrs {
+		if a[0] == name {
+			return a[1]
+		}
+	}
+	return ""
+}
+
+func (t *token) hasAttr(name string) bool {
+	for _, a := range t.attrs {
+		if a[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenizer a minimal html tokenizer, comments, doctypes and
+// processing instructions are skipped
+type tokenizer struct {
+	data []byte
+	pos  int
+}
+
+func (t *tokenizer) next() (tok token, ok bool) {
+	for t.pos < len(t.data) {
+		if t.data[t.pos] != '<' {
+			end := bytes.IndexByte(t.data[t.pos:], '<')
+			if end < 0 {
+				end = len(t.data) - t.pos
+			}
+			tok.kind, tok.data = textToken, html.UnescapeString(string(t.data[t.pos:t.pos+end]))
+			t.pos += end
+			return tok, true
+		}
+		rest := t.data[t.pos:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			t.skipPast(4, "-->")
+		case len(rest) > 1 && (rest[1] == '!' || rest[1] == '?'):
+			t.skipPast(2, ">")
+		case len(rest) > 1 && rest[1] == '/' && len(rest) > 2 && isLetter(rest[2]):
+			t.pos += 2
+			tok.kind, tok.name = endTagToken, t.tagName()
+			t.skipPast(0, ">")
+			return tok, true
+		case len(rest) > 1 && isLetter(rest[1]):
+			t.pos++
+			tok.kind, tok.name = startTagToken, t.tagName()
+			tok.attrs = t.attributes()
+			return tok, true
+		default: // a bare '<' is treated as text
+			t.pos++
+			tok.kind, tok.data = textToken, "<"
+			return tok, true
+		}
+	}
+	return tok, false
+}
+
+// skipPast skip n bytes, and then skip past the terminator
+func (t *tokenizer) skipPast(n int, terminator string) {
+	t.pos += n
+	i := bytes.Index(t.data[t.pos:], []byte(terminator))
+	if i < 0 {
+		t.pos = len(t.data)
+		return
+	}
+	t.pos += i + len(terminator)
+}
+
+func (t *tokenizer) tagName() string {
+	start := t.pos
+	for t.pos < len(t.data) && !isSpace(t.data[t.pos]) && t.data[t.pos] != '>' && t.data[t.pos] != '/' {
+		t.pos++
+	}
+	return strings.ToLower(string(t.data[start:t.pos]))
+}
+
+// attributes parse the attributes until the end of the tag,
+// the values can be double quoted, single quoted or unquoted
+func (t *tokenizer) attributes() (attrs [][2]string) {
+	for {
+		for t.pos < len(t.data) && (isSpace(t.data[t.pos]) || t.data[t.pos] == '/') {
+			t.pos++
+		}
+		if t.pos >= len(t.data) {
+			return
+		}
+		if t.data[t.pos] == '>' {
+			t.pos++
+			return
+		}
+		start := t.pos
+		for t.pos < len(t.data) && !isSpace(t.data[t.pos]) && !strings.ContainsRune("=>/", rune(t.data[t.pos])) {
+			t.pos++
+		}
+		if t.pos == start { // stray '=' or '/'
+			t.pos++
+			continue
+		}
+		name := strings.ToLower(string(t.data[start:t.pos]))
+		t.skipSpace()
+		var value string
+		if t.pos < len(t.data) && t.data[t.pos] == '=' {
+			t.pos++
+			t.skipSpace()
+			value = t.attrValue()
+		}
+		attrs = append(attrs, [2]string{name, value})
+	}
+}
+
+func (t *tokenizer) attrValue() string {
+	if t.pos >= len(t.data) {
+		return ""
+	}
+	var raw []byte
+	if q := t.data[t.pos]; q == '"' || q == '\'' {
+		t.pos++
+		end := bytes.IndexByte(t.data[t.pos:], q)
+		if end < 0 {
+			end = len(t.data) - t.pos
+		}
+		raw = t.data[t.pos : t.pos+end]
+		t.pos += end + 1
+	} else {
+		start := t.pos
+		for t.pos < len(t.data) && !isSpace(t.data[t.pos]) && t.data[t.pos] != '>' {
+			t.pos++
+		}
+		raw = t.data[start:t.pos]
+	}
+	return html.UnescapeString(string(raw))
+}
+
+// rawText return the raw content until the end tag of the element
+func (t *tokenizer) rawText(name string) string {
+	start := t.pos
+	lower := bytes.ToLower(t.data[t.pos:])
+	end := bytes.Index(lower, []byte("</"+name))
+	if end < 0 {
+		t.pos = len(t.data)
+		return string(t.data[start:])
+	}
+	t.pos += end
+	t.skipPast(0, ">")
+	return string(t.data[start : start+end])
+}
+
+func (t *tokenizer) skipSpace() {
+	for t.pos < len(t.data) && isSpace(t.data[t.pos]) {
+		t.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,125 @@
+package httpclient
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseFixture(t *testing.T, name string) []*htmlForm {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	forms, err := parseForms(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forms
+}
+
+func TestParseFormsLogin(t *testing.T) {
+	forms := parseFixture(t, "login.html")
+	if len(forms) != 1 {
+		t.Fatalf("expect 1 form, got %d", len(forms))
+	}
+	expect := url.Values{
+		"__VIEWSTATE":          {"dDwtMTIxNzQ5NTM0Mzs7Pg=="},
+		"__VIEWSTATEGENERATOR": {"C2EE9ABB"},
+		"__VIEWSTATEENCRYPTED": {""},
+		"userbh":               {""},
+		"pas2s":                {""},
+		"vcode":                {""},
+		"yxdm":                 {"10294"},
+		"cw":                   {"验证码错误!"},
+	}
+	if got := forms[0].values(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+}
+
+func TestParseFormsReport(t *testing.T) {
+	forms := parseFixture(t, "report.html")
+	if len(forms) != 3 {
+		t.Fatalf("expect 3 forms (including the orphan controls), got %d", len(forms))
+	}
+	if forms[0].Name != "search" || forms[0].Method != "get" {
+		t.Errorf("unexpected first form: %+v", forms[0])
+	}
+	if v := forms[2].values().Get("outside"); v != "orphan" {
+		t.Errorf("orphan control: got %q", v)
+	}
+
+	form, err := findForm(forms, "__VIEWSTATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.ID != "form1" || form.Action != "./r_3_3_st_jkdk.aspx" {
+		t.Errorf("unexpected report form: %+v", form)
+	}
+	expect := url.Values{
+		"__EVENTTARGET":   {""},
+		"__EVENTARGUMENT": {""},
+		"__VIEWSTATE":     {"dDwxNTA4NzY7Oz4="},
+		"xh":              {"1906010101"},
+		"xm":              {"张三 & 李四"},
+		"tbrq":            {"2022-05-01"},
+		"twqk":            {"1"},
+		"jkmys":           {"绿色"},
+		"sfzx":            {"否"},
+		"ck_brcnnrss":     {"on"},
+		"bz":              {"第一行 <备注>\n第二行"},
+		"cw":              {"信息填报不完整\r\n保存失败!"},
+	}
+	if got := form.values(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+
+	jkmys := form.field("jkmys")
+	var texts []string
+	for _, o := range jkmys.Options {
+		texts = append(texts, o.Value+"/"+o.Text)
+	}
+	if got := strings.Join(texts, ","); got != "绿色/绿色,黄色/黄色,红色/红色" {
+		t.Errorf("jkmys options: got %q", got)
+	}
+	if o := form.field("twqk").Options[1]; o.Text != "正常(37.3℃以下)" || !o.Selected {
+		t.Errorf("twqk option: got %+v", o)
+	}
+	if _, err = findForm(forms, "not-exist"); err != ErrFormNotFound {
+		t.Errorf("expect %v, got %v", ErrFormNotFound, err)
+	}
+}
+
+func TestParseFormsMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		html   string
+		expect url.Values
+	}{
+		{"unclosed tag", `<form><input name="a" value="1"`, url.Values{"a": {"1"}}},
+		{"unquoted with slash", `<input name=a value=1/>`, url.Values{"a": {"1/"}}},
+		{"spaces around equal", `<input name = "a" value = '1'>`, url.Values{"a": {"1"}}},
+		{"bare less than", `<p>1 < 2</p><input name="a" value="&lt;">`, url.Values{"a": {"<"}}},
+		{"unclosed comment", `<input name="a"><!-- <input name="b">`, url.Values{"a": {""}}},
+		{"duplicated names", `<input name="a" value="1"><input name="a" value="2">`, url.Values{"a": {"1", "2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forms, err := parseForms(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(forms) != 1 {
+				t.Fatalf("expect 1 form, got %d", len(forms))
+			}
+			if got := forms[0].values(); !reflect.DeepEqual(got, tt.expect) {
+				t.Fatalf("expect %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"image"
 	"image/jpeg"
 	"net/http"
@@ -16,6 +15,7 @@ import (
 
 	"github.com/yin1999/healthreport/v2/utils"
 	"github.com/yin1999/healthreport/v2/utils/captcha"
+	"github.com/yin1999/healthreport/v2/utils/metrics"
 )
 
 var (
@@ -30,10 +30,31 @@ var (
 	}
 )
 
-const loginURL = host + "/login.aspx"
+var (
+	// recognize the captcha recognizer, replaced in tests
+	recognize = captcha.Recognize
+	// retryWait the duration to wait before retrying login with a new captcha
+	retryWait = 2 * time.Second
+)
+
+var (
+	captchaRecognitions = metrics.Default.NewCounterVec("healthreport_captcha_recognitions_total",
+		"Total number of captcha recognition attempts.")
+	captchaFailures = metrics.Default.NewCounterVec("healthreport_captcha_recognition_failures_total",
+		"Total number of captcha recognition attempts which failed or got a code of wrong length.")
+	loginDuration = metrics.Default.NewHistogramVec("healthreport_login_duration_seconds",
+		"Latency of login, including the retries with new captchas.", metrics.DefaultBuckets, "result")
+)
 
 // login 登录系统
 func (c *punchClient) login(account *Account) (err error) {
+	defer func(start time.Time) {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		loginDuration.With(result).Observe(time.Since(start).Seconds())
+	}(time.Now())
 	hash := md5.New()
 	_, err = hash.Write([]byte(strings.ToUpper(account.Password)))
 	if err != nil {
@@ -51,7 +72,7 @@ func (c *punchClient) login(account *Account) (err error) {
 		err = loginPost(c, form)
 		switch err {
 		case ErrWrongCaptcha, ErrCannotRecognizeCaptcha:
-			if utils.Wait(c.ctx, time.Second*2) != nil {
+			if utils.Wait(c.ctx, retryWait) != nil {
 				return
 			}
 		default:
@@ -62,7 +83,7 @@ func (c *punchClient) login(account *Account) (err error) {
 }
 
 func loginGet(c *punchClient, form url.Values) error {
-	req, err := getWithContext(c.ctx, loginURL)
+	req, err := getWithContext(c.ctx, c.portal.BaseURL+c.portal.LoginPath)
 	if err != nil {
 		return err
 	}
@@ -72,6 +93,9 @@ func loginGet(c *punchClient, form url.Values) error {
 		return err
 	}
 	defer drainBody(res.Body)
+	if err = checkStatus("login", res); err != nil {
+		return err
+	}
 	return fillMap(res.Body, form, loginFormShouldFill)
 }
 
@@ -89,22 +113,26 @@ func loginPost(c *punchClient, form url.Values) (err error) {
 	form.Set("vcode", vcode)
 
 	var req *http.Request
-	req, err = postFormWithContext(c.ctx, loginURL, form)
+	req, err = postFormWithContext(c.ctx, c.portal.BaseURL+c.portal.LoginPath, form)
 	if err != nil {
 		return
 	}
 
 	c.httpClient.CheckRedirect = notRedirect
 	var res *http.Response
-	if res, err = c.httpClient.Do(req); err != nil {
+	res, err = c.httpClient.Do(req)
+	c.httpClient.CheckRedirect = c.checkRedirect
+	if err != nil {
 		return
 	}
-	c.httpClient.CheckRedirect = nil
 	defer drainBody(res.Body)
 
 	if res.StatusCode == http.StatusFound { // redirect after login success
 		return
 	}
+	if err = checkStatus("login", res); err != nil {
+		return
+	}
 	err = fillMap(res.Body, form, loginFormShouldFill) // if login failed, parse form data for next post
 	if err != nil {
 		return
@@ -112,15 +140,19 @@ func loginPost(c *punchClient, form url.Values) (err error) {
 	switch v := form.Get("cw"); v { // parse error message
 	case "验证码错误!":
 		err = ErrWrongCaptcha
-	default:
-		err = fmt.Errorf("login failed: %s", v)
+	case "用户名或密码错误!", "用户名或密码错误！":
+		err = &Error{Kind: KindAuth, Op: "login", Message: v}
+	case "": // no message, the portal may be busy
+		err = &Error{Kind: KindUnknown, Op: "login", Message: "login failed"}
+	default: // e.g. the system is busy, retry it rather than stopping as a wrong password
+		err = &Error{Kind: KindUnknown, Op: "login", Message: v}
 	}
 	return
 }
 
 func recognizeCaptcha(c *punchClient) (vcode string, err error) {
 	var req *http.Request
-	req, err = getWithContext(c.ctx, host+"/Vcode.ASPX")
+	req, err = getWithContext(c.ctx, c.portal.BaseURL+c.portal.CaptchaPath)
 	if err != nil {
 		return
 	}
@@ -135,12 +167,15 @@ func recognizeCaptcha(c *punchClient) (vcode string, err error) {
 			return
 		}
 
-		if vcode, err = captcha.Recognize(vImg); err != nil {
+		captchaRecognitions.With().Inc()
+		if vcode, err = recognize(vImg); err != nil {
+			captchaFailures.With().Inc()
 			return
 		}
 		if len(vcode) == 4 {
 			return
 		}
+		captchaFailures.With().Inc()
 		if err = utils.Wait(c.ctx, time.Second); err != nil {
 			return
 		}
@@ -151,8 +186,8 @@ func recognizeCaptcha(c *punchClient) (vcode string, err error) {
 
 func readImage(res *http.Response) (data []byte, err error) {
 	defer drainBody(res.Body)
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get captcha image failed: %s", res.Status)
+	if err = checkStatus("captcha", res); err != nil {
+		return
 	}
 	var img image.Image
 	img, err = jpeg.Decode(res.Body)
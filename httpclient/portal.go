@@ -0,0 +1,119 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrInvalidCA the CA bundle contains no certificate
+	ErrInvalidCA = errors.New("portal: no certificate found in the CA bundle")
+)
+
+// Portal the address of the portal, empty paths fall back to the default paths
+type Portal struct {
+	BaseURL     string `json:"baseURL"`               // e.g. "http://smst.hhu.edu.cn"
+	LoginPath   string `json:"loginPath,omitempty"`   // default: "/login.aspx"
+	CaptchaPath string `json:"captchaPath,omitempty"` // default: "/Vcode.ASPX"
+	ReportPath  string `json:"reportPath,omitempty"`  // default: "/Mobile/rsbulid/r_3_3_st_jkdk.aspx"
+	CAFile      string `json:"caFile,omitempty"`      // PEM encoded CA bundle trusted besides the system CAs
+}
+
+// DefaultPortal return the default portal
+func DefaultPortal() Portal {
+	return Portal{
+		BaseURL:     "http://smst.hhu.edu.cn",
+		LoginPath:   "/login.aspx",
+		CaptchaPath: "/Vcode.ASPX",
+		ReportPath:  "/Mobile/rsbulid/r_3_3_st_jkdk.aspx",
+	}
+}
+
+// portalConfig the portal and its transport, they are replaced together by SetPortal
+type portalConfig struct {
+	Portal
+	transport http.RoundTripper // nil for http.DefaultTransport
+}
+
+var (
+	portalMux sync.RWMutex
+	portal    = portalConfig{Portal: DefaultPortal()}
+)
+
+// currentPortal return the portal in use and its transport
+func currentPortal() portalConfig {
+	portalMux.RLock()
+	defer portalMux.RUnlock()
+	return portal
+}
+
+// SetPortal set the portal used by the following punches, the running punches keep the old one
+func SetPortal(p Portal) error {
+	def := DefaultPortal()
+	if p.BaseURL == "" {
+		p.BaseURL = def.BaseURL
+	}
+	u, err := url.Parse(p.BaseURL)
+	if err != nil {
+		return fmt.Errorf("portal: invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("portal: invalid base URL: %s", p.BaseURL)
+	}
+	p.BaseURL = strings.TrimSuffix(p.BaseURL, "/")
+	for _, v := range [...]struct {
+		path *string
+		def  string
+	}{
+		{&p.LoginPath, def.LoginPath},
+		{&p.CaptchaPath, def.CaptchaPath},
+		{&p.ReportPath, def.ReportPath},
+	} {
+		if *v.path == "" {
+			*v.path = v.def
+		} else if !strings.HasPrefix(*v.path, "/") {
+			*v.path = "/" + *v.path
+		}
+	}
+
+	var t http.RoundTripper
+	if p.CAFile != "" {
+		if t, err = newTransport(p.CAFile); err != nil {
+			return err
+		}
+	}
+	portalMux.Lock()
+	portal = portalConfig{Portal: p, transport: t}
+	portalMux.Unlock()
+	return nil
+}
+
+// GetPortal return the portal in use
+func GetPortal() Portal {
+	return currentPortal().Portal
+}
+
+// newTransport return a transport trusting the CAs in the file besides the system CAs
+func newTransport(caFile string) (http.RoundTripper, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrInvalidCA
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return t, nil
+}
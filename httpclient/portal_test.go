@@ -0,0 +1,88 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yin1999/healthreport/v2/httpclient/portaltest"
+)
+
+func TestSetPortal(t *testing.T) {
+	old := currentPortal()
+	t.Cleanup(func() { portal = old })
+
+	if err := SetPortal(Portal{BaseURL: "https://mirror.example.com/", ReportPath: "report.aspx"}); err != nil {
+		t.Fatal(err)
+	}
+	p := GetPortal()
+	if p.BaseURL != "https://mirror.example.com" {
+		t.Errorf("BaseURL: got %q", p.BaseURL)
+	}
+	if p.ReportPath != "/report.aspx" {
+		t.Errorf("ReportPath: got %q", p.ReportPath)
+	}
+	if p.LoginPath != DefaultPortal().LoginPath || p.CaptchaPath != DefaultPortal().CaptchaPath {
+		t.Errorf("default paths expected, got %+v", p)
+	}
+
+	for _, u := range []string{"smst.hhu.edu.cn", "ftp://smst.hhu.edu.cn", "http://"} {
+		if err := SetPortal(Portal{BaseURL: u}); err == nil {
+			t.Errorf("expect error for base URL %q", u)
+		}
+	}
+	if GetPortal() != p {
+		t.Error("portal changed by invalid config")
+	}
+
+	// a punch keeps the portal it started with
+	c := newClient(context.Background())
+	done := make(chan struct{})
+	go func() {
+		SetPortal(Portal{BaseURL: "https://other.example.com"})
+		close(done)
+	}()
+	<-done
+	if c.portal != p || GetPortal().BaseURL != "https://other.example.com" {
+		t.Errorf("expect the client to keep %+v, got %+v", p, c.portal)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPortal(Portal{BaseURL: p.BaseURL, CAFile: empty}); err != ErrInvalidCA {
+		t.Errorf("expect %v, got %v", ErrInvalidCA, err)
+	}
+}
+
+func TestTLSPortal(t *testing.T) {
+	p := portaltest.NewTLSPortal(testUsername, testPassword)
+	usePortal(t, p, false)
+	account := &Account{Username: testUsername, Password: testPassword}
+
+	// the certificate of the portal is not trusted
+	if err := SetPortal(Portal{BaseURL: p.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoginConfirm(context.Background(), account); err == nil {
+		t.Fatal("expect certificate error")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPortal(Portal{BaseURL: p.URL, CAFile: caFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Punch(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Posts()); n != 1 {
+		t.Fatalf("expect 1 report saved, got %d", n)
+	}
+}
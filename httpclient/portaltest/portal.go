@@ -0,0 +1,376 @@
+// Package portaltest provides a fake smst.hhu.edu.cn portal for testing.
+package portaltest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// paths served by the fake portal
+const (
+	LoginPath   = "/login.aspx"
+	CaptchaPath = "/Vcode.ASPX"
+	ReportPath  = "/Mobile/rsbulid/r_3_3_st_jkdk.aspx"
+)
+
+// messages returned in the `cw` field
+const (
+	MsgWrongCaptcha   = "验证码错误!"
+	MsgWrongPassword  = "用户名或密码错误!"
+	MsgIncompleteForm = "信息填报不完整\r\n保存失败!"
+	MsgSaved          = "保存修改成功!"
+)
+
+const (
+	sessionCookie = "ASP.NET_SessionId"
+	viewState     = "dDwtMTIxNzQ5NTM0Mzs7Pg=="
+)
+
+// requiredFields the fields must not be empty when the report form is posted
+var requiredFields = [...]string{"xh", "xm", "tbrq", "twqk", "sfzx", "jkmys"}
+
+// choice a field rendered as a select or a radio group
+type choice struct {
+	caption string      // text shown before the field
+	radio   bool        // render as a radio group
+	options [][2]string // value, text
+}
+
+// choices the fields rendered as selects or radio groups on the report page,
+// the other fields are rendered as hidden inputs
+var choices = map[string]choice{
+	"twqk":  {caption: "体温情况：", options: [][2]string{{"", "请选择"}, {"1", "正常(37.3℃以下)"}, {"2", "异常"}}},
+	"jkmys": {caption: "健康码颜色：", options: [][2]string{{"", "请选择"}, {"绿色", "绿色"}, {"黄色", "黄色"}, {"红色", "红色"}}},
+	"sfzx":  {caption: "是否在校：", radio: true, options: [][2]string{{"是", "是"}, {"否", "否"}}},
+}
+
+// Portal a fake portal server, it is safe for concurrent use
+type Portal struct {
+	*httptest.Server
+
+	mux      sync.Mutex
+	username string
+	password string // hex encoded md5 of the upper case password
+	captcha  string
+	sessions map[string]struct{}
+	fields   map[string]string
+	records  [][2]string // the report date and the saving time of the saved reports
+	posts    []url.Values
+	logins   int
+	rand     *rand.Rand
+
+	// Incomplete the report page leaves a required field empty
+	Incomplete bool
+	// FailStatus the report page responds with the status when it is not 0
+	FailStatus int
+	// Blank the report page responds with an empty body
+	Blank bool
+	// LoginMessage the login page responds with the message when it is not empty
+	LoginMessage string
+	// Now return the current time of the portal, default: time.Now
+	Now func() time.Time
+}
+
+// timeZone the time zone of the portal
+var timeZone = time.FixedZone("CST", 8*3600)
+
+// NewPortal start a fake portal that accepts the username and password
+func NewPortal(username, password string) *Portal {
+	p := newPortal(username, password)
+	p.Start()
+	return p
+}
+
+// NewTLSPortal start a fake portal serving HTTPS, the certificate
+// of the portal can be obtained by Portal.Certificate
+func NewTLSPortal(username, password string) *Portal {
+	p := newPortal(username, password)
+	p.StartTLS()
+	return p
+}
+
+func newPortal(username, password string) *Portal {
+	sum := md5.Sum([]byte(strings.ToUpper(password)))
+	p := &Portal{
+		username: username,
+		password: hex.EncodeToString(sum[:]),
+		sessions: make(map[string]struct{}),
+		rand:     rand.New(rand.NewSource(1)),
+		Now:      time.Now,
+		fields: map[string]string{
+			"__EVENTARGUMENT":      "",
+			"__VIEWSTATE":          viewState,
+			"__VIEWSTATEGENERATOR": "4F3A8D2C",
+			"xh":                   username,
+			"xm":                   "张三",
+			"tbrq":                 "2022-05-01",
+			"czsj":                 "2022-05-01 08:00:00",
+			"twqk":                 "1",
+			"twqkdm":               "1",
+			"sfzx":                 "是",
+			"sfzxdm":               "1",
+			"jkmys":                "绿色",
+			"jkmysdm":              "1",
+			"cw":                   "",
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(LoginPath, p.handleLogin)
+	mux.HandleFunc(CaptchaPath, p.handleCaptcha)
+	mux.HandleFunc(ReportPath, p.handleReport)
+	p.Server = httptest.NewUnstartedServer(mux)
+	return p
+}
+
+// Captcha return the current captcha code, empty if no captcha is generated
+func (p *Portal) Captcha() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.captcha
+}
+
+// Posts return the report forms posted successfully
+func (p *Portal) Posts() []url.Values {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]url.Values(nil), p.posts...)
+}
+
+// Logins return the number of successful logins
+func (p *Portal) Logins() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.logins
+}
+
+// Field return the value of a field on the report page
+func (p *Portal) Field(key string) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.fields[key]
+}
+
+// SetField set the value of a field on the report page
+func (p *Portal) SetField(key, value string) {
+	p.mux.Lock()
+	p.fields[key] = value
+	p.mux.Unlock()
+}
+
+// ExpireSessions invalidate all the logged in sessions
+func (p *Portal) ExpireSessions() {
+	p.mux.Lock()
+	p.sessions = make(map[string]struct{})
+	p.mux.Unlock()
+}
+
+func (p *Portal) handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeLoginPage(w, "")
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		code := p.captcha
+		p.captcha = "" // the captcha can only be used once
+		switch {
+		case r.PostForm.Get("__VIEWSTATE") != viewState:
+			http.Error(w, "invalid view state", http.StatusBadRequest)
+		case p.LoginMessage != "":
+			writeLoginPage(w, p.LoginMessage)
+		case code == "" || r.PostForm.Get("vcode") != code:
+			writeLoginPage(w, MsgWrongCaptcha)
+		case r.PostForm.Get("userbh") != p.username || r.PostForm.Get("pas2s") != p.password:
+			writeLoginPage(w, MsgWrongPassword)
+		default:
+			id := strconv.FormatInt(p.rand.Int63(), 36)
+			p.sessions[id] = struct{}{}
+			p.logins++
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true})
+			http.Redirect(w, r, "/Mobile/index.aspx", http.StatusFound)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *Portal) handleCaptcha(w http.ResponseWriter, r *http.Request) {
+	p.mux.Lock()
+	n := 1000 + p.rand.Intn(9000) // never starts with '0'
+	p.captcha = strconv.Itoa(n)
+	p.mux.Unlock()
+
+	// the code is not drawn, the client under test is expected to
+	// read it by Portal.Captcha instead of OCR
+	img := image.NewGray(image.Rect(0, 0, 60, 20))
+	w.Header().Set("Content-Type", "image/jpeg")
+	jpeg.Encode(w, img, nil)
+}
+
+func (p *Portal) handleReport(w http.ResponseWriter, r *http.Request) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !p.loggedIn(r) {
+		http.Redirect(w, r, LoginPath, http.StatusFound)
+		return
+	}
+	if p.Blank {
+		return
+	}
+	if p.FailStatus != 0 {
+		http.Error(w, http.StatusText(p.FailStatus), p.FailStatus)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		fields := make(map[string]string, len(p.fields))
+		for k, v := range p.fields {
+			fields[k] = v
+		}
+		if p.Incomplete {
+			fields["twqk"] = ""
+		}
+		fields["tbrq"] = p.Now().In(timeZone).Format("2006-01-02") // the report date is today
+		writeReportPage(w, fields, p.records)
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields := make(map[string]string, len(p.fields))
+		for k, v := range p.fields {
+			fields[k] = v
+		}
+		fields["cw"] = MsgSaved
+		for _, key := range requiredFields {
+			if r.PostForm.Get(key) == "" {
+				fields["cw"] = MsgIncompleteForm
+				break
+			}
+		}
+		if fields["cw"] == MsgSaved {
+			p.posts = append(p.posts, r.PostForm)
+			for key := range p.fields { // the saved values are pre-filled next time
+				if v, ok := r.PostForm[key]; ok && key != "cw" {
+					p.fields[key] = v[0]
+					fields[key] = v[0]
+				}
+			}
+			p.fields["czsj"] = p.Now().In(timeZone).Format("2006-01-02 15:04:05")
+			fields["czsj"] = p.fields["czsj"]
+			p.saveRecord(fields["tbrq"], fields["czsj"])
+		}
+		writeReportPage(w, fields, p.records)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// saveRecord add the record of the date to the record list, or update the saving time of it
+func (p *Portal) saveRecord(date, saved string) {
+	for i := range p.records {
+		if p.records[i][0] == date {
+			p.records[i][1] = saved
+			return
+		}
+	}
+	p.records = append([][2]string{{date, saved}}, p.records...) // the latest first
+}
+
+func (p *Portal) loggedIn(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	_, ok := p.sessions[c.Value]
+	return ok
+}
+
+func writeLoginPage(w http.ResponseWriter, errMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<html>\r\n<body>\r\n<form name=\"form1\" method=\"post\" action=\"login.aspx\" id=\"form1\">\r\n")
+	writeInput(w, "hidden", "__VIEWSTATE", viewState)
+	writeInput(w, "hidden", "__VIEWSTATEGENERATOR", "C2EE9ABB")
+	writeInput(w, "hidden", "__VIEWSTATEENCRYPTED", "")
+	writeInput(w, "text", "userbh", "")
+	writeInput(w, "password", "pas2s", "")
+	writeInput(w, "text", "vcode", "")
+	writeInput(w, "hidden", "yxdm", "10294")
+	writeInput(w, "hidden", "cw", errMsg)
+	fmt.Fprint(w, "</form>\r\n</body>\r\n</html>\r\n")
+}
+
+func writeReportPage(w http.ResponseWriter, fields map[string]string, records [][2]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<html>\r\n<body>\r\n<form name=\"form1\" method=\"post\" action=\"./r_3_3_st_jkdk.aspx\" id=\"form1\">\r\n")
+	for _, key := range sortedKeys(fields) {
+		if c, ok := choices[key]; ok {
+			writeChoice(w, key, c, fields[key])
+		} else {
+			writeInput(w, "hidden", key, fields[key])
+		}
+	}
+	fmt.Fprint(w, "  <table id=\"dgData00\">\r\n    <tr><td>填报日期</td><td>保存时间</td></tr>\r\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "    <tr><td>%s</td><td>%s</td></tr>\r\n", html.EscapeString(r[0]), html.EscapeString(r[1]))
+	}
+	fmt.Fprint(w, "  </table>\r\n</form>\r\n</body>\r\n</html>\r\n")
+}
+
+func writeChoice(w http.ResponseWriter, name string, c choice, value string) {
+	fmt.Fprintf(w, "  <table><tr><td>%s</td><td>\r\n", html.EscapeString(c.caption))
+	if c.radio {
+		for i, o := range c.options {
+			id := name + "_" + strconv.Itoa(i)
+			checked := ""
+			if o[0] == value {
+				checked = " checked=\"checked\""
+			}
+			fmt.Fprintf(w, "    <input id=\"%s\" type=\"radio\" name=\"%s\" value=\"%s\"%s /><label for=\"%s\">%s</label>\r\n",
+				id, name, html.EscapeString(o[0]), checked, id, html.EscapeString(o[1]))
+		}
+	} else {
+		fmt.Fprintf(w, "    <select name=\"%s\" id=\"%s\">\r\n", name, name)
+		for _, o := range c.options {
+			selected := ""
+			if o[0] == value {
+				selected = "selected=\"selected\" "
+			}
+			fmt.Fprintf(w, "      <option %svalue=\"%s\">%s</option>\r\n", selected, html.EscapeString(o[0]), html.EscapeString(o[1]))
+		}
+		fmt.Fprint(w, "    </select>\r\n")
+	}
+	fmt.Fprint(w, "  </td></tr></table>\r\n")
+}
+
+func writeInput(w http.ResponseWriter, typ, name, value string) {
+	// the portal encodes line breaks in attributes as character references
+	value = strings.NewReplacer("\r", "&#13;", "\n", "&#10;").Replace(html.EscapeString(value))
+	fmt.Fprintf(w, "  <input name=\"%s\" type=\"%s\" id=\"%s\" value=\"%s\" />\r\n", name, typ, name, value)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,89 @@
+package httpclient
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// FormField a field of the report form to be submitted
+type FormField struct {
+	Name  string
+	Label string // the human-readable label on the page, empty if not found
+	Value string // the values joined by ","
+	Text  string // the text of the chosen options, empty for free text fields
+}
+
+// Preview 登录并获取打卡表单，返回填入答案后将要提交的字段(按字段名排序)，不提交表单
+func Preview(ctx context.Context, account interface{}) (fields []FormField, err error) {
+	defer func() {
+		err = classify(err)
+	}()
+
+	a := account.(*Account)
+	c := newClient(ctx)
+	if err = c.login(a); err != nil {
+		return
+	}
+
+	var (
+		f    *htmlForm
+		form url.Values
+	)
+	if f, _, form, err = c.getFormDetail(a.Answers); err != nil {
+		return
+	}
+	return describeForm(f, form), nil
+}
+
+// describeForm describe the values to be submitted with the labels and option texts in the form
+func describeForm(f *htmlForm, form url.Values) []FormField {
+	fields := make([]FormField, 0, len(form))
+	for name, values := range form {
+		if values == nil { // not submitted
+			continue
+		}
+		field := FormField{Name: name, Value: strings.Join(values, ",")}
+		if group := f.group(name); len(group) != 0 {
+			field.Label = groupLabel(group)
+			field.Text = strings.Join(optionTexts(group, values), ",")
+		}
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	return fields
+}
+
+// groupLabel return the label of a field group
+func groupLabel(group []*formField) string {
+	if group[0].Type != "radio" && group[0].Label != "" {
+		return normalizeLabel(group[0].Label)
+	}
+	return normalizeLabel(group[0].Caption)
+}
+
+// optionTexts return the texts of the options with the values
+func optionTexts(group []*formField, values []string) (texts []string) {
+	for _, v := range values {
+		switch group[0].Type {
+		case "select":
+			for _, o := range group[0].Options {
+				if o.Value == v {
+					texts = append(texts, o.Text)
+					break
+				}
+			}
+		case "radio":
+			for _, f := range group {
+				if f.Value == v {
+					texts = append(texts, f.Label)
+					break
+				}
+			}
+		}
+	}
+	return
+}
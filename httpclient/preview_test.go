@@ -0,0 +1,43 @@
+package httpclient
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPreview(t *testing.T) {
+	p := newTestPortal(t, false)
+	account := &Account{
+		Username: testUsername,
+		Password: testPassword,
+		Answers:  Answers{"体温情况": "异常"},
+	}
+	fields, err := Preview(context.Background(), account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Posts()); n != 0 {
+		t.Fatalf("expect no report saved in preview, got %d", n)
+	}
+
+	expect := map[string]FormField{
+		"twqk":          {Name: "twqk", Label: "体温情况", Value: "2", Text: "异常"},
+		"sfzx":          {Name: "sfzx", Label: "是否在校", Value: "是", Text: "是"},
+		"xh":            {Name: "xh", Value: testUsername},
+		"__EVENTTARGET": {Name: "__EVENTTARGET", Value: "databc"},
+	}
+	for i, f := range fields {
+		if i > 0 && fields[i-1].Name >= f.Name {
+			t.Errorf("fields are not sorted: %s, %s", fields[i-1].Name, f.Name)
+		}
+		if e, ok := expect[f.Name]; ok {
+			if f != e {
+				t.Errorf("expect %+v, got %+v", e, f)
+			}
+			delete(expect, f.Name)
+		}
+	}
+	for name := range expect {
+		t.Errorf("field %s not found", name)
+	}
+}
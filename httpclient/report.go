@@ -1,11 +1,14 @@
 package httpclient
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 type htmlSymbol uint8
@@ -13,15 +16,36 @@ type htmlSymbol uint8
 const (
 	symbolJSON htmlSymbol = iota
 	symbolString
-
-	reportPath = "/Mobile/rsbulid/r_3_3_st_jkdk.aspx"
 )
 
 var (
 	//ErrIncompleteForm the form is incomplete
 	ErrIncompleteForm = errors.New("form: incomplete form")
+	// ErrAlreadyReported today's report already exists
+	ErrAlreadyReported error = alreadyReportedError{}
 )
 
+type alreadyReportedError struct{}
+
+func (alreadyReportedError) Error() string {
+	return "report: already reported today"
+}
+
+// AlreadyDone report that the punch is done before, so it need not be retried
+func (alreadyReportedError) AlreadyDone() bool {
+	return true
+}
+
+var (
+	// timeZone the time zone of the portal, China Standard Time
+	timeZone = time.FixedZone("CST", 8*3600)
+	// now return the current time, replaced in tests
+	now = time.Now
+)
+
+// dateLayouts the layouts of the dates on the report page
+var dateLayouts = [...]string{"2006-01-02", "2006-1-2", "2006/01/02", "2006/1/2"}
+
 var reportFields = [...]string{"__EVENTARGUMENT", "__VIEWSTATE", "__VIEWSTATEENCRYPTED", "__VIEWSTATEGENERATOR",
 	"bdbz", "bjhm", "brcnnrss", "brjkqk", "brjkqkdm", "ck_brcnnrss", "cw", "czsj",
 	"databcdel", "databcxs", "dcbz", "fjmf", "hjzd", "jjzt", "jkmys", "jkmysdm", "lszt",
@@ -34,10 +58,22 @@ var reportFields = [...]string{"__EVENTARGUMENT", "__VIEWSTATE", "__VIEWSTATEENC
 
 var fixedFields = map[string]string{"__EVENTTARGET": "databc"}
 
-// getFormDetail 获取打卡表单详细信息
-func (c *punchClient) getFormDetail() (form url.Values, err error) {
+// report 获取打卡表单，今日未打卡(或强制打卡)时提交表单
+func (c *punchClient) report(account *Account) error {
+	_, records, form, err := c.getFormDetail(account.Answers)
+	if err != nil {
+		return err
+	}
+	if !account.Force && reportedToday(records, now()) {
+		return ErrAlreadyReported
+	}
+	return c.postForm(form)
+}
+
+// getFormDetail 获取打卡表单详细信息，返回填入答案后的表单、打卡记录的日期及将要提交的字段
+func (c *punchClient) getFormDetail(answers Answers) (f *htmlForm, records []time.Time, form url.Values, err error) {
 	var req *http.Request
-	req, err = getWithContext(c.ctx, host+reportPath)
+	req, err = getWithContext(c.ctx, c.portal.BaseURL+c.portal.ReportPath)
 	if err != nil {
 		return
 	}
@@ -47,30 +83,128 @@ func (c *punchClient) getFormDetail() (form url.Values, err error) {
 		return
 	}
 	defer drainBody(res.Body)
+	if err = checkStatus("form", res); err != nil {
+		return
+	}
+
+	var (
+		data     []byte
+		forms    []*htmlForm
+		answered []string
+	)
+	if data, err = io.ReadAll(res.Body); err != nil {
+		return
+	}
+	if forms, err = parseForms(bytes.NewReader(data)); err == nil {
+		f, err = findForm(forms, "__VIEWSTATE")
+	}
+	if err == nil {
+		answered, err = answers.apply(f)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get form data failed, err: %w", err)
+	}
+	records = parseRecords(data)
 
-	form = make(url.Values, len(reportFields)+len(fixedFields))
+	values := f.values()
+	form = make(url.Values, len(reportFields)+len(answered)+len(fixedFields))
 	for _, key := range reportFields {
-		form[key] = nil
+		form[key] = values[key]
+	}
+	for _, key := range answered {
+		form[key] = values[key]
+	}
+	for key, value := range fixedFields {
+		form.Set(key, value)
 	}
+	return
+}
 
-	err = fillMap(res.Body, form, func(s string) bool {
-		return form.Has(s)
-	})
+// reportedToday report whether the record list has a record of today, the
+// form is not checked as the portal pre-fills it with today's date
+func reportedToday(records []time.Time, now time.Time) bool {
+	y, m, d := now.In(timeZone).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, timeZone)
+	for _, t := range records {
+		if t.Equal(today) {
+			return true
+		}
+	}
+	return false
+}
 
-	if err == nil {
-		for key, value := range fixedFields {
-			form.Set(key, value)
+// parseRecords return the dates of the records listed on the report page, that is,
+// the first date in each table row, the rows without a date(e.g. the header) are skipped
+func parseRecords(data []byte) (records []time.Time) {
+	var (
+		inCell bool
+		found  bool // the date of the row is found
+		cell   strings.Builder
+	)
+	endOfCell := func() {
+		if !inCell {
+			return
+		}
+		inCell = false
+		if found {
+			return
+		}
+		if date, ok := parseDate(cell.String()); ok {
+			records = append(records, date)
+			found = true
+		}
+	}
+	t := &tokenizer{data: data}
+	for {
+		tok, ok := t.next()
+		if !ok {
+			break
+		}
+		switch tok.kind {
+		case textToken:
+			if inCell {
+				cell.WriteString(tok.data)
+			}
+		case startTagToken:
+			switch tok.name {
+			case "tr":
+				endOfCell()
+				found = false
+			case "td", "th":
+				endOfCell()
+				inCell = true
+				cell.Reset()
+			case "script", "style", "textarea":
+				t.rawText(tok.name)
+			}
+		case endTagToken:
+			switch tok.name {
+			case "td", "th", "tr", "table":
+				endOfCell()
+			}
 		}
-	} else {
-		err = fmt.Errorf("get form data failed, err: %w", err)
 	}
 	return
 }
 
+// parseDate parse the date part of a date or date time string
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " T"); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, timeZone); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 // postForm 提交打卡表单
 func (c *punchClient) postForm(form url.Values) error {
 	req, err := postFormWithContext(c.ctx,
-		host+reportPath,
+		c.portal.BaseURL+c.portal.ReportPath,
 		form,
 	)
 	if err != nil {
@@ -83,19 +217,23 @@ func (c *punchClient) postForm(form url.Values) error {
 	}
 	defer drainBody(res.Body)
 
-	if res.StatusCode != http.StatusOK {
-		return errors.New("post failed, status: " + res.Status)
+	if err = checkStatus("post", res); err != nil {
+		return err
+	}
+	forms, err := parseForms(res.Body)
+	if err != nil {
+		return err
 	}
-	_, errorMsg, _ := parseHTML(bufio.NewReader(res.Body), `<input name="cw"`) // get the error message
+	errorMsg, _ := fieldValue(forms, "cw") // get the error message
 	switch errorMsg {
 	case "保存修改成功!", "增加记录成功!":
 		// success
 	case "信息填报不完整\r\n保存失败!":
-		err = ErrIncompleteForm
-	case "":
-		err = errors.New("post failed")
+		err = &Error{Kind: KindValidation, Op: "post", Message: errorMsg, Err: ErrIncompleteForm}
+	case "": // no message, the portal may be busy
+		err = &Error{Kind: KindUnknown, Op: "post", Message: "post failed"}
 	default:
-		err = errors.New("post failed, err: " + errorMsg)
+		err = &Error{Kind: KindUnknown, Op: "post", Message: errorMsg}
 	}
 	return err
 }
@@ -0,0 +1,95 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReportedToday(t *testing.T) {
+	today := time.Date(2022, 5, 1, 23, 30, 0, 0, timeZone)
+	tests := []struct {
+		rows   string
+		expect bool
+	}{
+		{"<tr><td>2022-05-01</td><td>2022-05-01 08:00:00</td></tr>", true},
+		{"<tr><th>填报日期</th></tr><tr><td>1</td><td>2022/5/1</td></tr>", true},
+		{"<tr><td>2022-04-30</td><td>2022-05-01 08:00:00</td></tr>", false}, // the date of the record is the first one
+		{"<tr><td>2022-04-30</td></tr><tr><td>2022-04-29</td></tr>", false},
+		{"<tr><td>填报日期</td></tr>", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		records := parseRecords([]byte("<table>" + tt.rows + "</table>"))
+		if got := reportedToday(records, today); got != tt.expect {
+			t.Errorf("rows: %q: expect %v, got %v", tt.rows, tt.expect, got)
+		}
+	}
+	// the date is in China Standard Time
+	records := parseRecords([]byte("<table><tr><td>2022-05-02</td></tr></table>"))
+	if !reportedToday(records, today.UTC().Add(time.Hour)) {
+		t.Error("expect the date in China Standard Time")
+	}
+}
+
+func TestReportedTodayPrefilled(t *testing.T) {
+	data, err := os.ReadFile("testdata/report_prefilled.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := parseRecords(data)
+	expect := []time.Time{time.Date(2022, 4, 30, 0, 0, 0, 0, timeZone), time.Date(2022, 4, 29, 0, 0, 0, 0, timeZone)}
+	if !reflect.DeepEqual(records, expect) {
+		t.Fatalf("expect records %v, got %v", expect, records)
+	}
+	// the form is pre-filled with today's date, but today's report is not submitted
+	if reportedToday(records, time.Date(2022, 5, 1, 8, 0, 0, 0, timeZone)) {
+		t.Error("expect the pre-filled report not reported")
+	}
+	if !reportedToday(records, time.Date(2022, 4, 30, 20, 0, 0, 0, timeZone)) {
+		t.Error("expect the listed report reported")
+	}
+}
+
+func TestPunchAlreadyReported(t *testing.T) {
+	p := newTestPortal(t, false)
+	account := &Account{Username: testUsername, Password: testPassword}
+	ctx := context.Background()
+	if err := Punch(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	err := Punch(ctx, account)
+	if err != ErrAlreadyReported {
+		t.Fatalf("expect %v, got %v", ErrAlreadyReported, err)
+	}
+	var d interface{ AlreadyDone() bool }
+	if !errors.As(err, &d) || !d.AlreadyDone() {
+		t.Fatal("expect ErrAlreadyReported to report AlreadyDone")
+	}
+	if n := len(p.Posts()); n != 1 {
+		t.Fatalf("expect 1 report saved, got %d", n)
+	}
+
+	account.Force = true
+	if err = Punch(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Posts()); n != 2 {
+		t.Fatalf("expect the report to be forced, got %d saved", n)
+	}
+
+	// a new day
+	p.Now = func() time.Time { return time.Now().AddDate(0, 0, 1) }
+	now = p.Now
+	t.Cleanup(func() { now = time.Now })
+	account.Force = false
+	if err = Punch(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Posts()); n != 3 {
+		t.Fatalf("expect 3 reports saved, got %d", n)
+	}
+}
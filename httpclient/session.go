@@ -0,0 +1,131 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrSessionExpired the portal redirects to the login page
+	ErrSessionExpired = errors.New("session: expired")
+)
+
+// SessionManager keep the authenticated cookies of the accounts,
+// so that the following punches can skip the login
+type SessionManager struct {
+	dir  string
+	mux  sync.Mutex
+	jars map[string]*cookieJar
+}
+
+// NewSessionManager return a session manager, the sessions are persisted
+// in dir with 0600 permissions. If dir is empty, the sessions are kept
+// in memory only.
+func NewSessionManager(dir string) *SessionManager {
+	return &SessionManager{
+		dir:  dir,
+		jars: make(map[string]*cookieJar),
+	}
+}
+
+// LoginConfirm 验证账号密码，并保存登录状态
+func (m *SessionManager) LoginConfirm(ctx context.Context, account interface{}) error {
+	a := account.(*Account)
+	c := newClientWithJar(ctx, newCookieJar())
+	err := c.login(a)
+	if err == nil {
+		err = m.store(a.Username, c.jar)
+	}
+	return classify(err)
+}
+
+// Punch 打卡，优先使用已保存的登录状态，登录状态失效时重新登录
+func (m *SessionManager) Punch(ctx context.Context, account interface{}) (err error) {
+	defer func() {
+		err = classify(err)
+	}()
+
+	a := account.(*Account)
+	jar := m.load(a.Username)
+	c := newClientWithJar(ctx, jar)
+
+	reuse := jar.len() != 0
+	if reuse {
+		err = c.report(a)
+	}
+	if !reuse || errors.Is(err, ErrSessionExpired) {
+		c = newClientWithJar(ctx, newCookieJar()) // drop the expired cookies
+		if err = c.login(a); err != nil {
+			return
+		}
+		if err = m.store(a.Username, c.jar); err != nil {
+			return
+		}
+		err = c.report(a)
+	}
+	return
+}
+
+// load return the cookie jar of the user, an empty jar is returned if not found
+func (m *SessionManager) load(username string) *cookieJar {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if jar, ok := m.jars[username]; ok {
+		return jar
+	}
+	jar := newCookieJar()
+	if m.dir != "" {
+		if f, err := os.Open(m.filename(username)); err == nil {
+			if json.NewDecoder(f).Decode(jar) != nil {
+				jar = newCookieJar()
+			}
+			f.Close()
+		}
+	}
+	m.jars[username] = jar
+	return jar
+}
+
+// store save the cookie jar of the user
+func (m *SessionManager) store(username string, jar *cookieJar) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.jars[username] = jar
+	if m.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.filename(username), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(jar)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (m *SessionManager) filename(username string) string {
+	return filepath.Join(m.dir, url.PathEscape(username)+".json")
+}
+
+// checkRedirect treat redirecting to the login page as session expired
+func (c *punchClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if strings.EqualFold(req.URL.Path, c.portal.LoginPath) {
+		return ErrSessionExpired
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package httpclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionManager(t *testing.T) {
+	p := newTestPortal(t, false)
+	dir := filepath.Join(t.TempDir(), "sessions")
+	account := &Account{Username: testUsername, Password: testPassword, Force: true}
+	ctx := context.Background()
+
+	m := NewSessionManager(dir)
+	if err := m.LoginConfirm(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.Punch(ctx, account); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := p.Logins(); n != 1 {
+		t.Fatalf("expect the session to be reused, got %d logins", n)
+	}
+
+	info, err := os.Stat(m.filename(testUsername))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expect session file permission 0600, got %o", perm)
+	}
+
+	// a new manager loads the session from disk
+	if err = NewSessionManager(dir).Punch(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Logins(); n != 1 {
+		t.Fatalf("expect the persisted session to be reused, got %d logins", n)
+	}
+
+	// login again after the session expired
+	p.ExpireSessions()
+	if err = m.Punch(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Logins(); n != 2 {
+		t.Fatalf("expect login after session expired, got %d logins", n)
+	}
+	if n := len(p.Posts()); n != 4 {
+		t.Fatalf("expect 4 reports saved, got %d", n)
+	}
+}
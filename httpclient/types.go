@@ -7,13 +7,17 @@ import (
 
 type punchClient struct {
 	ctx        context.Context
+	jar        *cookieJar
 	httpClient *http.Client
+	portal     Portal // the portal when the client is created, used until the punch finishes
 }
 
 // Account account info for login
 type Account struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string  `json:"username"`
+	Password string  `json:"password"`
+	Answers  Answers `json:"answers,omitempty"` // answers to override the pre-filled report form
+	Force    bool    `json:"force,omitempty"`   // submit even if today's report already exists
 }
 
 // Name get the name of the account
@@ -3,21 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"syscall"
 	"time"
 
 	client "github.com/yin1999/healthreport/v2/httpclient"
-	"github.com/yin1999/healthreport/v2/serve"
-	"github.com/yin1999/healthreport/v2/utils"
 	"github.com/yin1999/healthreport/v2/utils/captcha"
 	"github.com/yin1999/healthreport/v2/utils/config"
 	"github.com/yin1999/healthreport/v2/utils/email"
+	"github.com/yin1999/healthreport/v2/utils/notify"
 	"github.com/yin1999/healthreport/v2/utils/systemd"
 )
 
@@ -31,97 +33,256 @@ var (
 const (
 	mailNickName = "打卡状态推送"
 
-	retryAfter   = 5 * time.Minute
 	punchTimeout = 30 * time.Second
+	flushTimeout = 10 * time.Second // deliver the queued notifications on shutdown
 )
 
 var (
 	cfg     = config.Config{}
+	defCfg  config.Config // the defaults of cfg, the config file is loaded on top of it
 	account = &client.Account{}
+	flagSet *flag.FlagSet
 
-	mailConfigPath  string
-	accountFilename string // 账户信息存储文件名
-	logger          = log.Default()
+	timeZone = time.FixedZone("CST", 8*3600) // China Standard Time Zone
+
+	mailConfigPath   string
+	notifyConfigPath string
+	notifyQueueDir   string // 通知队列目录
+	accountFilename  string // 账户信息存储文件名
+	accountsFilename string // 多账户配置文件名
+	portalConfigPath string
+	portalURL        string
+	portalCA         string
+	sessionDir       string // 登录状态存储目录
+	stateDir         string // 打卡状态存储目录
+	answersFilename  string // 打卡表单答案文件名
+	historyFilename  string // 打卡历史记录文件名
+	metricsAddr      string // 监控指标监听地址
+	apiAddr          string // 控制接口监听地址
+	dashboardAddr    string // 网页面板监听地址
+	dashboard        dashboardAuth
+	dashboardCert    dashboardTLS
+	dryRun           bool
+	force            bool // 今日已打卡时仍然提交
+	configPath       string
+	logger           = log.Default()
 )
 
 func main() {
+	initApp() // parse the args here rather than in init, so that the tests can run
+	if dryRun {
+		if err := preview(); err != nil {
+			logger.Fatalln(err.Error())
+		}
+		return
+	}
+
 	defer logger.Print("Exit\n")
 	logger.Print("Start program\n")
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	captcha.Init() // the captcha engine is shared by all the accounts
+	defer captcha.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if metricsAddr != "" {
+		srv, err := serveMetrics(metricsAddr)
+		if err != nil {
+			logger.Fatalf("metrics: listen failed(Err: %s)\n", err.Error())
+		}
+		defer srv.Close()
+	}
+	d := newDaemon(sessionDir, stateDir, historyFilename)
+	if notifyQueueDir != "" {
+		if q, err := notify.OpenQueue(notifyQueueDir); err != nil {
+			logger.Printf("notify: open queue failed, send the notifications directly(Err: %s)\n", err.Error())
+		} else {
+			d.queue = q
+		}
+	}
+	if err := load(ctx, d); err != nil {
+		logger.Fatalln(err.Error())
+	}
+	d.runQueue(ctx)
+	reloads := make(chan chan error) // reload requests from the api
+	if apiAddr != "" {
+		srv, err := serveAPI(apiAddr, &api{d: d, reload: func() error {
+			res := make(chan error, 1)
+			reloads <- res
+			return <-res
+		}})
+		if err != nil {
+			logger.Fatalf("api: listen failed(Err: %s)\n", err.Error())
+		}
+		defer srv.Close()
+	}
+	if dashboardAddr != "" {
+		srv, err := serveDashboard(dashboardAddr, &api{d: d}, dashboard, dashboardCert)
+		if err != nil {
+			logger.Fatalf("dashboard: start failed(Err: %s)\n", err.Error())
+		}
+		defer srv.Close()
+	}
+	systemd.Notify(systemd.Ready)
+
 	for {
-		ctx, cc := context.WithCancel(context.Background())
-		exit := false
-		go func() {
-			switch <-c {
+		select {
+		case res := <-reloads:
+			res <- reload(ctx, d)
+		case sig := <-c:
+			switch sig {
 			case syscall.SIGHUP:
-				systemd.Notify(systemd.Reloading)
-				cc()
+				reload(ctx, d)
 			case syscall.SIGINT, syscall.SIGTERM:
 				systemd.Notify(systemd.Stopping)
-				exit = true
-				cc()
+				cancel()
+				d.wait()
+				d.flush(flushTimeout)
+				return
 			}
-		}()
-		app(ctx, func() {
-			systemd.Notify(systemd.Ready)
-		})
-		if exit {
-			break
 		}
-		initApp() // load config
 	}
 }
 
-func app(ctx context.Context, ready func()) {
+// reload reload the config, the running services are kept if failed
+func reload(ctx context.Context, d *daemon) error {
+	systemd.Notify(systemd.Reloading)
+	defer systemd.Notify(systemd.Ready)
+	err := load(ctx, d)
+	if err != nil {
+		logger.Printf("Reload failed, keep the running services, err: %s\n", err.Error())
+	}
+	return err
+}
+
+// load (re)load the config, the portal, the accounts and the email config, then apply them
+// to the daemon. Everything is loaded and validated first, so that nothing is applied and
+// the running services are kept if any of them is invalid
+func load(ctx context.Context, d *daemon) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	p, err := loadPortal()
+	if err != nil {
+		return err
+	}
+	accounts, err := loadAccounts(c)
+	if err != nil {
+		return err
+	}
+	notifier, err := loadNotifier()
+	if err != nil {
+		return err
+	}
+	if err = client.SetPortal(p); err != nil { // validated before any of them is applied
+		return err
+	}
+	cfg = c
+	logger.Printf("Portal: %s\n", client.GetPortal().BaseURL)
 	cfg.Show(logger)
-	captcha.Init()
-	defer captcha.Close()
+	logger.Printf("Loaded %d account(s)\n", len(accounts))
+	d.apply(ctx, accounts, notifier)
+	return nil
+}
 
-	emailCfg, err := email.LoadConfig(mailConfigPath)
-	if err == nil {
-		logger.Print("Email deliver enabled\n")
+// loadNotifier load the notification channels, the email config is used as a smtp channel
+func loadNotifier() (notify.Notifiers, error) {
+	var channels notify.Notifiers
+	if emailCfg, err := email.LoadConfig(mailConfigPath); err == nil {
+		channels = append(channels, notify.Channel{Name: "email", Type: "smtp", Notifier: notify.NewSMTP(emailCfg, mailNickName)})
+	}
+	n, err := notify.Load(notifyConfigPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("notify: load config failed(Err: %w)", err)
 	}
+	channels = append(channels, n...)
+	for _, c := range channels {
+		logger.Printf("Notification channel enabled: %s(%s)\n", c.Name, c.Type)
+	}
+	return channels, nil
+}
 
-	logger.Print("正在验证账号密码\n")
-	err = client.LoginConfirm(ctx, account)
+// loadAccounts load accounts from the accounts file if provided,
+// otherwise use the account from args or the account file, the config c is applied to them
+func loadAccounts(c config.Config) (accounts []config.Account, err error) {
+	if accountsFilename != "" {
+		accounts, err = config.LoadAccounts(accountsFilename)
+	} else {
+		a := *account
+		if a.Username == "" && a.Password == "" {
+			err = loadJson(&a, accountFilename)
+		}
+		accounts = []config.Account{{Username: a.Username, Password: a.Password, Answers: a.Answers}}
+		if err == nil {
+			err = config.CheckAccounts(accounts)
+		}
+	}
 	if err != nil {
-		logger.Fatalf("验证密码失败(Err: %s)\n", err.Error())
-	}
-	ready()
-	logger.Print("账号密码验证成功，将在5秒后开始打卡\n")
-
-	serveCfg := &serve.Config{
-		Sender:      emailCfg,
-		Logger:      logger,
-		MaxAttempts: cfg.MaxAttempts,
-		Time: serve.Time{
-			Hour:     cfg.PunchTime.Hour,
-			Minute:   cfg.PunchTime.Minute,
-			TimeZone: time.FixedZone("CST", 8*3600), // China Standard Time Zone,
-		},
-		MailNickName: mailNickName,
-		Timeout:      punchTimeout,
-		RetryAfter:   retryAfter,
-		PunchFunc:    client.Punch,
-	}
-
-	if utils.Wait(ctx, 5*time.Second) != nil {
-		return
+		return nil, err
+	}
+	answers, err := client.LoadAnswers(answersFilename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("answers: load failed(Err: %w)", err)
 	}
+	for i := range accounts {
+		accounts[i] = accounts[i].Apply(c)
+		accounts[i].Force = accounts[i].Force || force
+		if len(answers) != 0 {
+			accounts[i].Answers = answers.Merge(accounts[i].Answers)
+		}
+		if err = accounts[i].LoadHolidayFile(timeZone); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
 
-	err = serveCfg.PunchServe(ctx, account)
-	if err != nil && err != context.Canceled {
-		logger.Fatalln(err.Error())
+// loadConfig load the config file on top of the defaults, and then apply the args
+// again, so that the args take precedence over the config file and the fields
+// removed from the config file are reset. cfg is not changed, the args are parsed
+// into it(the flags are bound to it) and it is restored afterwards
+func loadConfig() (config.Config, error) {
+	c := defCfg
+	if err := c.Load(configPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return c, fmt.Errorf("config: load failed(Err: %w)", err)
 	}
+	old := cfg
+	cfg = c
+	err := flagSet.Parse(os.Args[1:])
+	c, cfg = cfg, old
+	return c, err
 }
 
-func init() {
-	initApp()
+// loadPortal load the portal config, the priority is: args > env > config file > default,
+// it is validated and applied by client.SetPortal
+func loadPortal() (client.Portal, error) {
+	p := client.DefaultPortal()
+	if err := loadJson(&p, portalConfigPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return p, fmt.Errorf("portal: load config failed(Err: %w)", err)
+	}
+	for _, v := range [...]struct {
+		field    *string
+		env, arg string
+	}{
+		{&p.BaseURL, os.Getenv("HEALTHREPORT_PORTAL_URL"), portalURL},
+		{&p.CAFile, os.Getenv("HEALTHREPORT_PORTAL_CA"), portalCA},
+	} {
+		if v.arg != "" {
+			*v.field = v.arg
+		} else if v.env != "" {
+			*v.field = v.env
+		}
+	}
+	return p, nil
 }
 
 func initApp() {
-	flagSet := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	runCommand(os.Args[1:])
+
+	flagSet = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	version := flagSet.Bool("v", false, "show version and exit")
 	checkEmail := flagSet.Bool("e", false, "check email")
 	genEmailCfg := flagSet.Bool("g", false, "generate email config")
@@ -130,8 +291,30 @@ func initApp() {
 	flagSet.StringVar(&account.Username, "u", "", "set username")
 	flagSet.StringVar(&account.Password, "p", "", "set password")
 	flagSet.StringVar(&mailConfigPath, "email", "email.json", "set email config file path")
+	flagSet.StringVar(&notifyConfigPath, "notify", "notify.json", "set notification config file path(json object with key 'channels', a list of channels with keys 'type'(smtp, webhook, url, telegram or command), 'name', 'events', 'digest', 'digestAt', 'digestDay' and the keys of the type)")
+	flagSet.StringVar(&notifyQueueDir, "notify-queue", statePath("notify-queue"), "set the `directory` of the notification queue, the failed notifications are retried from it, empty to send the notifications directly, inspect it with the notify-queue subcommand(default: $STATE_DIRECTORY/notify-queue of the systemd service, otherwise empty)")
 	flagSet.StringVar(&accountFilename, "account", "account.json", "set account file path(json format with keys:'username','password')")
+	flagSet.StringVar(&accountsFilename, "accounts", "", "set accounts file path for multiple accounts(json array with keys:'username','password','punchTime','maxAttempts','notify','answers','force','retry','holidays','holidayFile','startup'), overrides -u, -p and -account")
+	flagSet.StringVar(&portalConfigPath, "portal", "portal.json", "set portal config file path(json format with keys:'baseURL','loginPath','captchaPath','reportPath','caFile')")
+	flagSet.StringVar(&portalURL, "portal-url", "", "set portal base `URL`(env: HEALTHREPORT_PORTAL_URL), e.g. https://smst.hhu.edu.cn")
+	flagSet.StringVar(&portalCA, "portal-ca", "", "set PEM encoded CA bundle `file` for the portal(env: HEALTHREPORT_PORTAL_CA)")
+	flagSet.StringVar(&sessionDir, "session-dir", "", "set `directory` to persist login sessions across restarts(default: keep in memory)")
+	flagSet.StringVar(&stateDir, "state-dir", statePath("state"), "set `directory` to persist the last successful punch across restarts, empty to keep it in memory(default: $STATE_DIRECTORY/state of the systemd service, otherwise empty)")
+	flagSet.StringVar(&answersFilename, "answers", "answers.json", "set answers file path for the report form(json object maps field names or labels to values)")
+	flagSet.StringVar(&historyFilename, "history", statePath("history.jsonl"), "set punch history `file` path, empty to disable the history, list it with the history subcommand(default: $STATE_DIRECTORY/history.jsonl of the systemd service, otherwise empty)")
+	flagSet.StringVar(&metricsAddr, "metrics-addr", "", "expose Prometheus metrics at /metrics of the `address`, e.g. 127.0.0.1:9090(default: disabled)")
+	flagSet.StringVar(&apiAddr, "api-addr", "", "serve the control API(GET /status, POST /punch/{account}, POST /reload, GET /history) on the loopback `address` or unix:/path/to/socket(default: disabled)")
+	flagSet.StringVar(&dashboardAddr, "dashboard-addr", "", "serve the web dashboard on the `address`, e.g. 127.0.0.1:8080(default: disabled), a password or a token is required, and -dashboard-cert and -dashboard-key on a non-loopback address")
+	flagSet.StringVar(&dashboardCert.certFile, "dashboard-cert", "", "serve the dashboard over https with the PEM encoded certificate `file`")
+	flagSet.StringVar(&dashboardCert.keyFile, "dashboard-key", "", "set the PEM encoded private key `file` of the dashboard certificate")
+	flagSet.StringVar(&dashboard.user, "dashboard-user", "admin", "set the basic auth `username` of the dashboard")
+	flagSet.StringVar(&dashboard.password, "dashboard-password", os.Getenv("HEALTHREPORT_DASHBOARD_PASSWORD"), "set the basic auth `password` of the dashboard(env: HEALTHREPORT_DASHBOARD_PASSWORD)")
+	flagSet.StringVar(&dashboard.token, "dashboard-token", os.Getenv("HEALTHREPORT_DASHBOARD_TOKEN"), "set the `token` of the dashboard, passed by the Authorization: Bearer header(env: HEALTHREPORT_DASHBOARD_TOKEN)")
+	flagSet.BoolVar(&dryRun, "dry-run", false, "log in, print the report form that would be submitted and exit without submitting")
+	flagSet.BoolVar(&force, "force", false, "submit the report even if today's report already exists")
+	flagSet.StringVar(&configPath, "config", "config.json", "set config file path(json format with keys:'maxAttempts','punchTime','retry','holidays','holidayFile','startup'), overridden by args")
 	cfg.SetFlag(flagSet)
+	defCfg = cfg
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
 		if err == flag.ErrHelp {
 			os.Exit(0)
@@ -173,13 +356,6 @@ func initApp() {
 
 	fromArgs := account.Username != "" || account.Password != ""
 
-	if !fromArgs {
-		err := loadJson(account, accountFilename)
-		if err != nil {
-			logger.Fatalln(err.Error())
-		}
-	}
-
 	if *save && fromArgs {
 		if err := storeJson(account, accountFilename); err != nil {
 			logger.Fatalf("account: save to file failed(Err: %s)\n", err.Error())
@@ -188,6 +364,16 @@ func initApp() {
 	}
 }
 
+// statePath return the path of the name in the state directory of the systemd
+// service($STATE_DIRECTORY), empty if the directory is not set, as the working
+// directory may be read-only
+func statePath(name string) string {
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return filepath.Join(dir, name)
+	}
+	return ""
+}
+
 func loadJson(v interface{}, name string) error {
 	f, err := os.Open(name)
 	if err != nil {
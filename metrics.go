@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils/metrics"
+)
+
+var (
+	punchAttempts = metrics.Default.NewCounterVec("healthreport_punch_attempts_total",
+		"Total number of punch attempts.", "account")
+	punchSuccesses = metrics.Default.NewCounterVec("healthreport_punch_successes_total",
+		"Total number of punch attempts which succeeded or found the punch already done.", "account")
+	punchFailures = metrics.Default.NewCounterVec("healthreport_punch_failures_total",
+		"Total number of failed punch attempts by error kind.", "account", "kind")
+	lastSuccess = metrics.Default.NewGaugeVec("healthreport_last_success_timestamp_seconds",
+		"Unix time of the last successful punch.", "account")
+	nextPunch = metrics.Default.NewGaugeVec("healthreport_next_punch_timestamp_seconds",
+		"Unix time of the next scheduled punch.", "account")
+)
+
+// metricsRecorder update the punch metrics with the records
+type metricsRecorder struct{}
+
+func (metricsRecorder) Record(r serve.Record) error {
+	switch r.Status {
+	case serve.StatusSkipped:
+		return nil
+	case serve.StatusSucceeded, serve.StatusAlreadyDone:
+		punchSuccesses.With(r.Account).Inc()
+		lastSuccess.With(r.Account).Set(float64(r.End.Unix()))
+	default:
+		kind := r.Kind
+		if kind == "" {
+			kind = "unknown"
+		}
+		punchFailures.With(r.Account, kind).Inc()
+	}
+	punchAttempts.With(r.Account).Inc()
+	return nil
+}
+
+// recorders record to all the recorders, the first error is returned
+type recorders []serve.Recorder
+
+func (rs recorders) Record(r serve.Record) (err error) {
+	for _, recorder := range rs {
+		if e := recorder.Record(r); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// serveMetrics expose the metrics at /metrics of addr in the background
+func serveMetrics(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			logger.Printf("Metrics server stopped, err: %s\n", err.Error())
+		}
+	}()
+	logger.Printf("Metrics exposed at http://%s/metrics\n", l.Addr())
+	return srv, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/yin1999/healthreport/v2/utils/notify"
+)
+
+// notifyQueueCommand inspect the notification queue: healthreport notify-queue [flags]
+func notifyQueueCommand(args []string) error {
+	fs := flag.NewFlagSet("notify-queue", flag.ContinueOnError)
+	dir := fs.String("queue", "notify-queue", "set the notification queue `directory`")
+	retry := fs.String("retry", "", "retry the message of the `key` as soon as possible, `all` for all the stuck messages, the running service picks it up within a minute")
+	drop := fs.String("drop", "", "drop the message of the `key` from the queue")
+	format := fs.String("format", "table", "set output `format`: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+	q, err := notify.OpenQueue(*dir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *retry != "":
+		key := *retry
+		if key == "all" {
+			key = ""
+		}
+		n, err := q.Retry(key)
+		fmt.Printf("%d message(s) will be retried\n", n)
+		return err
+	case *drop != "":
+		if err = q.Remove(*drop); err == nil {
+			fmt.Printf("message %s dropped\n", *drop)
+		}
+		return err
+	}
+
+	messages, err := q.List()
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "table":
+		return writeQueueTable(messages, q.MaxAttempts)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		if messages == nil {
+			messages = []notify.Message{}
+		}
+		return enc.Encode(messages)
+	}
+	return fmt.Errorf("notify-queue: unknown format: %s", *format)
+}
+
+func writeQueueTable(messages []notify.Message, maxAttempts int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, "KEY\tCHANNEL\tEVENT\tACCOUNT\tCREATED\tATTEMPTS\tNEXT RETRY\tLAST ERROR\n")
+	for _, m := range messages {
+		next := m.NextRetry.In(timeZone).Format("2006-01-02 15:04:05")
+		if m.Stuck(maxAttempts) {
+			next = "stuck"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%q\n", m.Key, m.Channel, m.Event.Type, m.Event.Account,
+			m.Created.In(timeZone).Format("2006-01-02 15:04:05"), m.Attempts, next, m.LastError)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	client "github.com/yin1999/healthreport/v2/httpclient"
+	"github.com/yin1999/healthreport/v2/utils/captcha"
+)
+
+// preview log in with every account and print the report form that
+// would be submitted, without submitting it
+func preview() error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	p, err := loadPortal()
+	if err != nil {
+		return err
+	}
+	accounts, err := loadAccounts(c)
+	if err != nil {
+		return err
+	}
+	if err = client.SetPortal(p); err != nil {
+		return err
+	}
+	captcha.Init()
+	defer captcha.Close()
+
+	for _, spec := range accounts {
+		ctx, cancel := context.WithTimeout(context.Background(), punchTimeout)
+		fields, err := client.Preview(ctx, &client.Account{
+			Username: spec.Username,
+			Password: spec.Password,
+			Answers:  spec.Answers,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("account %s: preview failed(Err: %w)", spec.Username, err)
+		}
+
+		fmt.Printf("账户: %s (dry run, not submitted)\n", spec.Username)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprint(w, "FIELD\tLABEL\tVALUE\n")
+		for _, f := range fields {
+			value := f.Value
+			if f.Text != "" && f.Text != f.Value {
+				value += "(" + f.Text + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%q\n", f.Name, f.Label, value)
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
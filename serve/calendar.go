@@ -0,0 +1,236 @@
+package serve
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCalendar the calendar cannot be parsed
+var ErrInvalidCalendar = errors.New("calendar: invalid format")
+
+// Calendar decide the days when the punch is skipped
+type Calendar interface {
+	// Holiday report whether the date is a holiday, and the name of the holiday
+	Holiday(date Date) (name string, ok bool)
+}
+
+// Date a date without time and time zone
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf return the date of the time in its location
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{year, month, day}
+}
+
+// ParseDate parse a date in `YYYY-MM-DD` format
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return Date{}, fmt.Errorf("%w: invalid date: %q", ErrInvalidCalendar, s)
+	}
+	return DateOf(t), nil
+}
+
+// Before report whether the date is before d
+func (date Date) Before(d Date) bool {
+	if date.Year != d.Year {
+		return date.Year < d.Year
+	}
+	if date.Month != d.Month {
+		return date.Month < d.Month
+	}
+	return date.Day < d.Day
+}
+
+// AddDays return the date n days after
+func (date Date) AddDays(n int) Date {
+	return DateOf(time.Date(date.Year, date.Month, date.Day+n, 0, 0, 0, 0, time.UTC))
+}
+
+// String return the date in `YYYY-MM-DD` format
+func (date Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", date.Year, date.Month, date.Day)
+}
+
+// MarshalText implement encoding.TextMarshaler
+func (date Date) MarshalText() ([]byte, error) {
+	return []byte(date.String()), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler
+func (date *Date) UnmarshalText(text []byte) (err error) {
+	*date, err = ParseDate(string(text))
+	return
+}
+
+// DateRange the dates from Start to End, both ends are included
+type DateRange struct {
+	Start Date   `json:"start"`
+	End   Date   `json:"end"` // the same as Start if not set
+	Name  string `json:"name,omitempty"`
+}
+
+// Contains report whether the date is in the range
+func (r DateRange) Contains(date Date) bool {
+	end := r.End
+	if end == (Date{}) {
+		end = r.Start
+	}
+	return !date.Before(r.Start) && !end.Before(date)
+}
+
+// Holidays a Calendar of date ranges
+type Holidays []DateRange
+
+// Holiday implement Calendar
+func (h Holidays) Holiday(date Date) (string, bool) {
+	for _, r := range h {
+		if r.Contains(date) {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+// ParseICS read the events of an iCalendar(RFC 5545) file as holidays.
+// The times with UTC or a known TZID are converted into the location, and
+// the other times are taken as local times. Recurrence rules are not supported.
+func ParseICS(r io.Reader, loc *time.Location) (Holidays, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		holidays Holidays
+		event    *icsEvent
+	)
+	for _, line := range lines {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = &icsEvent{}
+		case event == nil:
+			// not in an event
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			r, err := event.dateRange()
+			if err != nil {
+				return nil, err
+			}
+			holidays = append(holidays, r)
+			event = nil
+		case name == "DTSTART":
+			event.start, event.allDay, err = parseICSTime(params, value, loc)
+		case name == "DTEND":
+			event.end, _, err = parseICSTime(params, value, loc)
+		case name == "SUMMARY":
+			event.summary = unescapeICS(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if event != nil {
+		return nil, fmt.Errorf("%w: unterminated event", ErrInvalidCalendar)
+	}
+	return holidays, nil
+}
+
+type icsEvent struct {
+	start, end time.Time
+	allDay     bool
+	summary    string
+}
+
+// dateRange return the dates covered by the event, the end of an event is exclusive
+func (e *icsEvent) dateRange() (DateRange, error) {
+	if e.start.IsZero() {
+		return DateRange{}, fmt.Errorf("%w: event without DTSTART", ErrInvalidCalendar)
+	}
+	r := DateRange{Start: DateOf(e.start), End: DateOf(e.start), Name: e.summary}
+	if e.end.After(e.start) {
+		r.End = DateOf(e.end)
+		midnight := e.end.Equal(time.Date(r.End.Year, r.End.Month, r.End.Day, 0, 0, 0, 0, e.end.Location()))
+		if e.allDay || midnight {
+			r.End = r.End.AddDays(-1)
+		}
+	}
+	return r, nil
+}
+
+// unfoldICS read the content lines, the folded lines are joined
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitICSLine split a content line `NAME;PARAM=VALUE:value`
+func splitICSLine(line string) (name string, params map[string]string, value string) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	value = line[i+1:]
+	parts := strings.Split(line[:i], ";")
+	name = strings.ToUpper(parts[0])
+	for _, p := range parts[1:] {
+		if j := strings.IndexByte(p, '='); j > 0 {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[strings.ToUpper(p[:j])] = strings.Trim(p[j+1:], `"`)
+		}
+	}
+	return
+}
+
+// parseICSTime parse a DATE or DATE-TIME value
+func parseICSTime(params map[string]string, value string, loc *time.Location) (t time.Time, allDay bool, err error) {
+	switch {
+	case params["VALUE"] == "DATE" || len(value) == len("20060102"):
+		t, err = time.ParseInLocation("20060102", value, loc)
+		allDay = true
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+		t = t.In(loc)
+	default:
+		zone := loc
+		if tzid := params["TZID"]; tzid != "" {
+			if l, e := time.LoadLocation(tzid); e == nil {
+				zone = l
+			}
+		}
+		t, err = time.ParseInLocation("20060102T150405", value, zone)
+		t = t.In(loc)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: invalid time: %q", ErrInvalidCalendar, value)
+	}
+	return
+}
+
+var icsUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, "\n", `\N`, "\n")
+
+func unescapeICS(s string) string {
+	return icsUnescaper.Replace(s)
+}
@@ -0,0 +1,142 @@
+package serve
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//test//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20220501\r\n" +
+	"DTEND;VALUE=DATE:20220504\r\n" +
+	"SUMMARY:Labour Day\\, holiday\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20220603\r\n" +
+	"SUMMARY:Dragon Boat\r\n" +
+	"  Festival\r\n" + // folded line
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20220701T170000Z\r\n" + // 2022-07-02 01:00 CST
+	"DTEND:20220702T160000Z\r\n" + // 2022-07-03 00:00 CST
+	"SUMMARY:Summer\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=\"Asia/Shanghai\":20221001T080000\r\n" +
+	"DTEND;TZID=Asia/Shanghai:20221003T120000\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	holidays, err := ParseICS(strings.NewReader(testICS), cst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Holidays{
+		{Date{2022, 5, 1}, Date{2022, 5, 3}, "Labour Day, holiday"},
+		{Date{2022, 6, 3}, Date{2022, 6, 3}, "Dragon Boat Festival"},
+		{Date{2022, 7, 2}, Date{2022, 7, 2}, "Summer"},
+		{Date{2022, 10, 1}, Date{2022, 10, 3}, ""},
+	}
+	if !reflect.DeepEqual(holidays, want) {
+		t.Errorf("expect %v, got %v", want, holidays)
+	}
+
+	for _, ics := range []string{
+		"BEGIN:VEVENT\nSUMMARY:no start\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:2022-05-01\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:20220501\n",
+	} {
+		if _, err = ParseICS(strings.NewReader(ics), cst); !errors.Is(err, ErrInvalidCalendar) {
+			t.Errorf("%q: expect ErrInvalidCalendar, got %v", ics, err)
+		}
+	}
+}
+
+func TestHolidays(t *testing.T) {
+	holidays := Holidays{
+		{Start: Date{2022, 7, 1}, End: Date{2022, 8, 31}, Name: "summer"},
+		{Start: Date{2022, 10, 1}, Name: "national day"},
+	}
+	tests := []struct {
+		date Date
+		name string
+		ok   bool
+	}{
+		{Date{2022, 6, 30}, "", false},
+		{Date{2022, 7, 1}, "summer", true},
+		{Date{2022, 8, 31}, "summer", true},
+		{Date{2022, 9, 1}, "", false},
+		{Date{2022, 10, 1}, "national day", true},
+		{Date{2022, 10, 2}, "", false},
+	}
+	for _, tt := range tests {
+		if name, ok := holidays.Holiday(tt.date); name != tt.name || ok != tt.ok {
+			t.Errorf("%s: expect %q(%v), got %q(%v)", tt.date, tt.name, tt.ok, name, ok)
+		}
+	}
+
+	var date Date
+	if err := date.UnmarshalText([]byte("2022-02-29")); !errors.Is(err, ErrInvalidCalendar) {
+		t.Errorf("expect ErrInvalidCalendar, got %v", err)
+	}
+	if date.UnmarshalText([]byte("2022-12-31")) != nil || date.AddDays(1) != (Date{2023, 1, 1}) {
+		t.Errorf("unexpected date: %s", date)
+	}
+}
+
+func TestPunchServeHoliday(t *testing.T) {
+	start := time.Date(2022, 5, 5, 7, 0, 0, 0, cst)
+	schedule, err := ParseSchedule("08:00,20:00 jitter=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := &testNotifier{}
+	recorder := &testRecorder{}
+	cfg := Config{
+		Time:     Time{TimeZone: cst},
+		Schedule: schedule,
+		Notifier: notifier,
+		Recorder: recorder,
+		Calendar: Holidays{{Start: Date{2022, 5, 6}, End: Date{2022, 5, 8}, Name: "vacation"}},
+	}
+
+	s := startServe(cfg, start)
+	defer s.stop(t)
+	want := []time.Time{start, time.Date(2022, 5, 9, 8, 0, 0, 0, cst), time.Date(2022, 5, 10, 8, 0, 0, 0, cst)}
+	if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+		t.Errorf("expect punches at %v, got %v", want, times)
+	}
+	if len(notifier.bodies(EventSkipped)) != 3 {
+		t.Fatalf("expect a message for each holiday, got %v", notifier.bodies(EventSkipped))
+	}
+	for _, body := range notifier.bodies(EventSkipped) {
+		if !strings.Contains(body, "skipped: holiday(vacation)") {
+			t.Errorf("unexpected message: %q", body)
+		}
+	}
+	recorder.mux.Lock()
+	skipped := 0
+	for _, r := range recorder.records {
+		if r.Status == StatusSkipped && r.Message == "vacation" {
+			skipped++
+		}
+	}
+	recorder.mux.Unlock()
+	if skipped != 3 {
+		t.Errorf("expect 3 skipped records, got %+v", recorder.records)
+	}
+
+	// the punch on start is skipped on a holiday too
+	s = startServe(cfg, time.Date(2022, 5, 8, 7, 0, 0, 0, cst))
+	defer s.stop(t)
+	want = []time.Time{time.Date(2022, 5, 9, 8, 0, 0, 0, cst)}
+	if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+		t.Errorf("expect punches at %v, got %v", want, times)
+	}
+}
@@ -0,0 +1,37 @@
+package serve
+
+import "time"
+
+// Clock provide the current time and timers, the zero Config uses the system clock
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer a timer created by a Clock, see time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock the Clock based on the time package
+type SystemClock struct{}
+
+// Now implement Clock
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer implement Clock
+func (SystemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -0,0 +1,122 @@
+package serve
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock a Clock whose time only moves when advance is called
+type fakeClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{} // the active timers
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, timers: make(map[*fakeTimer]struct{})}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// first return the earliest active timer, nil if no timer is active
+func (c *fakeClock) first() *fakeTimer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var first *fakeTimer
+	for timer := range c.timers {
+		if first == nil || timer.when.Before(first.when) {
+			first = timer
+		}
+	}
+	return first
+}
+
+// next wait until a timer is active, and return the earliest one
+func (c *fakeClock) next(t *testing.T) *fakeTimer {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if first := c.first(); first != nil {
+			return first
+		}
+	}
+	t.Fatal("no timer is waiting")
+	return nil
+}
+
+// advance move the time to the earliest active timer and fire it,
+// return false if no timer is active
+func (c *fakeClock) advance() bool {
+	timer := c.first()
+	if timer == nil {
+		return false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = timer.when
+	timer.fire()
+	return true
+}
+
+// active return the number of the active timers
+func (c *fakeClock) active() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.timers)
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	c     chan time.Time
+	when  time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	_, ok := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return ok
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	_, ok := t.clock.timers[t]
+	t.when = t.clock.now.Add(d)
+	if d <= 0 {
+		t.fire()
+	} else {
+		t.clock.timers[t] = struct{}{}
+	}
+	return ok
+}
+
+// fire send the time to the channel, the clock must be locked
+func (t *fakeTimer) fire() {
+	delete(t.clock.timers, t)
+	select {
+	case t.c <- t.clock.now:
+	default:
+	}
+}
+
+// fixedSource a rand.Source always returning the value
+type fixedSource int64
+
+func (s fixedSource) Int63() int64 { return int64(s) }
+func (fixedSource) Seed(int64)     {}
@@ -0,0 +1,158 @@
+package serve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron a standard cron expression with five fields:
+// minute, hour, day of month, month and day of week.
+// Each field is `*`, a value, a range `a-b`, a step `*/n` or `a-b/n`,
+// or a comma separated list of them. Months and days of week
+// can be names(jan-dec, sun-sat), and 7 is also Sunday.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bitsets of the values
+
+	// a day matches if both the day of month and the day of week match
+	// when any of them is `*`, otherwise if either of them matches
+	domStar, dowStar bool
+
+	spec string
+}
+
+type cronRange struct {
+	min, max int
+	names    []string
+}
+
+var cronRanges = [...]cronRange{
+	{0, 59, nil},
+	{0, 23, nil},
+	{1, 31, nil},
+	{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// ParseCron parse a cron expression
+func ParseCron(spec string) (*Cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronRanges) {
+		return nil, fmt.Errorf("%w: cron expression must have %d fields: %q", ErrInvalidSchedule, len(cronRanges), spec)
+	}
+	var bits [len(cronRanges)]uint64
+	for i, field := range fields {
+		var err error
+		if bits[i], err = cronRanges[i].parse(field); err != nil {
+			return nil, err
+		}
+	}
+	if bits[4]&(1<<7) != 0 { // 7 is Sunday
+		bits[4] |= 1
+	}
+	return &Cron{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+		spec:    strings.Join(fields, " "),
+	}, nil
+}
+
+// String return the cron expression
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// parse parse a field into a bitset
+func (r cronRange) parse(field string) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("%w: invalid step in cron field %q", ErrInvalidSchedule, field)
+			}
+			part = part[:i]
+		}
+
+		var low, high int
+		if part == "*" {
+			low, high = r.min, r.max
+		} else {
+			bound := part
+			if i := strings.IndexByte(part, '-'); i >= 0 {
+				bound = part[:i]
+				if high, err = r.value(part[i+1:]); err != nil {
+					return 0, err
+				}
+			}
+			if low, err = r.value(bound); err != nil {
+				return 0, err
+			}
+			if bound == part {
+				high = low
+				if step != 1 { // `a/n` means from a to the max
+					high = r.max
+				}
+			}
+			if low > high {
+				return 0, fmt.Errorf("%w: invalid range in cron field %q", ErrInvalidSchedule, field)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return
+}
+
+// value parse a number or a name of the field
+func (r cronRange) value(s string) (int, error) {
+	for i, name := range r.names {
+		if strings.EqualFold(s, name) {
+			return r.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < r.min || v > r.max {
+		return 0, fmt.Errorf("%w: invalid value in cron expression: %q", ErrInvalidSchedule, s)
+	}
+	return v, nil
+}
+
+// matchDay report whether the cron runs on the day
+func (c *Cron) matchDay(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// times return the times of the day when the cron runs in order
+func (c *Cron) times(date time.Time) []time.Time {
+	if !c.matchDay(date) {
+		return nil
+	}
+	year, month, day := date.Date()
+	var times []time.Time
+	for h := 0; h < 24; h++ {
+		if c.hour&(1<<h) == 0 {
+			continue
+		}
+		for m := 0; m < 60; m++ {
+			if c.minute&(1<<m) != 0 {
+				times = append(times, time.Date(year, month, day, h, m, 0, 0, date.Location()))
+			}
+		}
+	}
+	return times
+}
@@ -0,0 +1,126 @@
+package serve
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// EventType the type of a notification event
+type EventType string
+
+// The result of a punch routine is notified by one of EventSuccess,
+// EventRecovered, EventFinalFailure and EventSkipped.
+const (
+	// EventSuccess the punch succeeded at the first attempt, or has been done before
+	EventSuccess EventType = "success"
+	// EventFirstFailure the first attempt failed, and the punch will be retried
+	EventFirstFailure EventType = "first-failure"
+	// EventRecovered the punch succeeded after the failed attempts
+	EventRecovered EventType = "recovered"
+	// EventFinalFailure the punch failed and will not be retried
+	EventFinalFailure EventType = "final-failure"
+	// EventSkipped the punch is skipped on a holiday
+	EventSkipped EventType = "skipped"
+)
+
+// Event a notification about the punch of an account
+type Event struct {
+	ID      string    `json:"id"` // unique for each event
+	Type    EventType `json:"type"`
+	Account string    `json:"account"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`              // the message in plain text
+	Attempt int       `json:"attempt,omitempty"` // the number of the attempts made
+	Kind    string    `json:"kind,omitempty"`    // the error kind of a failure
+	Message string    `json:"message,omitempty"` // the message from the server, or the holiday name
+	Error   string    `json:"error,omitempty"`
+}
+
+// Notifier deliver the notification events
+type Notifier interface {
+	Notify(e Event) error
+}
+
+// NotifierFunc adapt a function to Notifier
+type NotifierFunc func(e Event) error
+
+// Notify implement Notifier
+func (f NotifierFunc) Notify(e Event) error {
+	return f(e)
+}
+
+// newEvent return an event of the account happened now
+func (cfg *Config) newEvent(account Account, typ EventType) Event {
+	now := cfg.clock().Now().In(cfg.Time.TimeZone)
+	return Event{
+		ID:      fmt.Sprintf("%s-%s-%d", url.PathEscape(account.Name()), typ, now.UnixNano()),
+		Type:    typ,
+		Account: account.Name(),
+		Time:    now,
+		Subject: fmt.Sprintf("打卡状态推送-%s", now.Format("2006-01-02")),
+	}
+}
+
+func (cfg *Config) notify(e Event) {
+	if cfg.Notifier == nil {
+		return
+	}
+	if err := cfg.Notifier.Notify(e); err != nil {
+		cfg.Logger.Printf("Send message failed, err: %s\n", err.Error())
+	}
+}
+
+// notifySkipped send a message about the punch skipped on a holiday
+func (cfg *Config) notifySkipped(account Account, holiday string) {
+	e := cfg.newEvent(account, EventSkipped)
+	reason := "skipped: holiday"
+	if holiday != "" {
+		reason += "(" + holiday + ")"
+	}
+	e.Message = holiday
+	e.Body = fmt.Sprintf("账户: %s 跳过打卡(%s)", account.Name(), reason)
+	cfg.notify(e)
+}
+
+// notifySuccess send a message about the success after the attempts
+func (cfg *Config) notifySuccess(account Account, attempt int, status Status) {
+	typ := EventSuccess
+	if attempt > 1 {
+		typ = EventRecovered
+	}
+	e := cfg.newEvent(account, typ)
+	e.Attempt = attempt
+	switch {
+	case status == StatusAlreadyDone:
+		e.Body = fmt.Sprintf("账户: %s 今日已打卡", account.Name())
+	case typ == EventRecovered:
+		e.Body = fmt.Sprintf("账户: %s 打卡成功(尝试 %d 次)", account.Name(), attempt)
+	default:
+		e.Body = fmt.Sprintf("账户: %s 打卡成功", account.Name())
+	}
+	cfg.notify(e)
+}
+
+// notifyFirstFailure send a message about the failure of the first attempt
+func (cfg *Config) notifyFirstFailure(account Account, err error) {
+	e := cfg.newEvent(account, EventFirstFailure)
+	e.Attempt = 1
+	e.Kind = errorKind(err)
+	e.Message = serverMessage(err)
+	e.Error = err.Error()
+	e.Body = fmt.Sprintf("账户: %s 首次打卡失败, 将会重试(类型: %s, err: %s)", account.Name(), e.Kind, e.Error)
+	cfg.notify(e)
+}
+
+// notifyFailure send a message about the failure after the attempts
+func (cfg *Config) notifyFailure(account Account, attempt int, err error) {
+	e := cfg.newEvent(account, EventFinalFailure)
+	e.Attempt = attempt
+	e.Kind = errorKind(err)
+	e.Message = serverMessage(err)
+	e.Error = err.Error()
+	e.Body = fmt.Sprintf("账户: %s 打卡失败(类型: %s, err: %s)", account.Name(), e.Kind, e.Error)
+	cfg.notify(e)
+}
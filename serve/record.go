@@ -0,0 +1,81 @@
+package serve
+
+import (
+	"errors"
+	"time"
+)
+
+// Record the record of a punch attempt, or a punch skipped on a holiday
+type Record struct {
+	Account string    `json:"account"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Attempt int       `json:"attempt"` // starting from 1, 0 for a skipped punch
+	Status  Status    `json:"status"`
+	Kind    string    `json:"kind,omitempty"`    // the error kind of a failed attempt
+	Message string    `json:"message,omitempty"` // the message from the server(e.g. the `cw` field), or the holiday name
+	Error   string    `json:"error,omitempty"`
+}
+
+// Recorder record the punch attempts
+type Recorder interface {
+	Record(r Record) error
+}
+
+// messenger is implemented by the errors returned by PunchFunc which
+// carry a message from the server
+type messenger interface {
+	ServerMessage() string
+}
+
+// serverMessage return the message from the server carried by the error
+func serverMessage(err error) string {
+	var m messenger
+	if errors.As(err, &m) {
+		return m.ServerMessage()
+	}
+	return ""
+}
+
+// record record an attempt, the err is the error returned by PunchFunc
+func (cfg *Config) record(account Account, start time.Time, attempt int, err error) {
+	r := Record{
+		Account: account.Name(),
+		Start:   start,
+		End:     cfg.clock().Now(),
+		Attempt: attempt,
+	}
+	switch {
+	case alreadyDone(err):
+		r.Status = StatusAlreadyDone
+	case err == nil:
+		r.Status = StatusSucceeded
+	default:
+		r.Status = StatusFailed
+		r.Kind = errorKind(err)
+		r.Message = serverMessage(err)
+		r.Error = err.Error()
+	}
+	cfg.saveRecord(r)
+}
+
+// recordSkipped record a punch skipped on the holiday
+func (cfg *Config) recordSkipped(account Account, holiday string) {
+	now := cfg.clock().Now()
+	cfg.saveRecord(Record{
+		Account: account.Name(),
+		Start:   now,
+		End:     now,
+		Status:  StatusSkipped,
+		Message: holiday,
+	})
+}
+
+func (cfg *Config) saveRecord(r Record) {
+	if cfg.Recorder == nil {
+		return
+	}
+	if err := cfg.Recorder.Record(r); err != nil {
+		cfg.Logger.Printf("Save history failed, err: %s\n", err.Error())
+	}
+}
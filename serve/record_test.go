@@ -0,0 +1,84 @@
+package serve
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type messageError struct {
+	testError
+	message string
+}
+
+func (e *messageError) ServerMessage() string { return e.message }
+
+type testRecorder struct {
+	mux     sync.Mutex
+	records []Record
+}
+
+func (r *testRecorder) Record(record Record) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.records = append(r.records, record)
+	return nil
+}
+
+func TestPunchRecord(t *testing.T) {
+	start := time.Date(2022, 5, 1, 8, 0, 0, 0, cst)
+	clock := newFakeClock(start)
+	recorder := &testRecorder{}
+	errs := []error{
+		&messageError{testError{"validation", true}, "信息填报不完整"},
+		doneError{},
+	}
+	cfg := &Config{
+		Logger:      discardLogger{},
+		MaxAttempts: 3,
+		Time:        Time{TimeZone: cst},
+		Timeout:     time.Second,
+		RetryAfter:  time.Minute,
+		Clock:       clock,
+		Recorder:    recorder,
+		PunchFunc: func(ctx context.Context, account interface{}) error {
+			err := errs[0]
+			errs = errs[1:]
+			return err
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cfg.punch(context.Background(), testAccount("test"))
+	}()
+	clock.next(t)
+	clock.advance()
+	<-done
+
+	want := []Record{
+		{Account: "test", Start: start, End: start, Attempt: 1, Status: StatusFailed,
+			Kind: "validation", Message: "信息填报不完整", Error: "validation error"},
+		{Account: "test", Start: start.Add(time.Minute), End: start.Add(time.Minute), Attempt: 2, Status: StatusAlreadyDone},
+	}
+	if !reflect.DeepEqual(recorder.records, want) {
+		t.Errorf("expect records %+v, got %+v", want, recorder.records)
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	for _, s := range []Status{StatusSucceeded, StatusAlreadyDone, StatusFailed, StatusSkipped} {
+		text, _ := s.MarshalText()
+		var got Status
+		if err := got.UnmarshalText(text); err != nil || got != s {
+			t.Errorf("%s: got %v, err: %v", s, got, err)
+		}
+	}
+	var s Status
+	if s.UnmarshalText([]byte("unknown")) == nil {
+		t.Error("expect an error for unknown status")
+	}
+}
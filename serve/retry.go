@@ -0,0 +1,82 @@
+package serve
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decide the delay before retrying a failed punch
+type RetryPolicy interface {
+	// Next return the delay before the next attempt after the n-th (starting from 1)
+	// failed attempt, ok is false if no more attempts should be made
+	Next(n int) (delay time.Duration, ok bool)
+}
+
+// ConstantBackoff retry after a constant delay
+type ConstantBackoff time.Duration
+
+// Next implement RetryPolicy
+func (b ConstantBackoff) Next(n int) (time.Duration, bool) {
+	return time.Duration(b), true
+}
+
+// ExponentialBackoff retry with exponentially growing delays:
+// Initial, Initial*Multiplier, Initial*Multiplier^2... capped by Max,
+// and then reduced by a random fraction up to Jitter
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration // the cap of the delay, 0 for no cap
+	Multiplier float64       // default: 2
+	Jitter     float64       // in [0, 1], 0 for no jitter
+
+	mux  sync.Mutex
+	rand *rand.Rand
+}
+
+// NewExponentialBackoff return an exponential backoff policy, the jitter is
+// generated from the source. If source is nil, a time seeded source is used.
+func NewExponentialBackoff(initial, max time.Duration, multiplier, jitter float64, source rand.Source) *ExponentialBackoff {
+	if source == nil {
+		source = rand.NewSource(time.Now().UnixNano())
+	}
+	return &ExponentialBackoff{
+		Initial:    initial,
+		Max:        max,
+		Multiplier: multiplier,
+		Jitter:     jitter,
+		rand:       rand.New(source),
+	}
+}
+
+// Next implement RetryPolicy
+func (b *ExponentialBackoff) Next(n int) (time.Duration, bool) {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(n-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if jitter := math.Min(math.Max(b.Jitter, 0), 1); jitter > 0 {
+		b.mux.Lock()
+		if b.rand == nil {
+			b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		f := b.rand.Float64()
+		b.mux.Unlock()
+		delay -= delay * jitter * f
+	}
+	if delay >= math.MaxInt64 { // avoid overflow
+		return math.MaxInt64, true
+	}
+	return time.Duration(delay), true
+}
+
+// todayClock return the time at hour:minute of the day of t in the location
+func todayClock(t time.Time, hour, minute int, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, hour, minute, 0, 0, loc)
+}
@@ -0,0 +1,148 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff(5 * time.Minute)
+	for n := 1; n < 5; n++ {
+		if d, ok := b.Next(n); d != 5*time.Minute || !ok {
+			t.Fatalf("attempt %d: expect 5m, got %v, %v", n, d, ok)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := NewExponentialBackoff(time.Minute, 10*time.Minute, 2, 0, nil)
+	expect := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, e := range expect {
+		if d, ok := b.Next(i + 1); d != e || !ok {
+			t.Errorf("attempt %d: expect %v, got %v, %v", i+1, e, d, ok)
+		}
+	}
+
+	// without cap, the delay never overflows
+	b = &ExponentialBackoff{Initial: time.Hour}
+	if d, _ := b.Next(200); d <= 0 {
+		t.Errorf("expect positive delay, got %v", d)
+	}
+
+	// the jitter is deterministic with a seeded source
+	b1 := NewExponentialBackoff(time.Minute, time.Hour, 3, 0.5, rand.NewSource(42))
+	b2 := NewExponentialBackoff(time.Minute, time.Hour, 3, 0.5, rand.NewSource(42))
+	for n := 1; n <= 6; n++ {
+		d1, _ := b1.Next(n)
+		d2, _ := b2.Next(n)
+		if d1 != d2 {
+			t.Fatalf("attempt %d: expect the same delay with the same seed, got %v and %v", n, d1, d2)
+		}
+		max := time.Duration(float64(time.Minute) * pow(3, n-1))
+		if max > time.Hour {
+			max = time.Hour
+		}
+		if d1 > max || d1 < max/2 {
+			t.Errorf("attempt %d: expect delay in [%v, %v], got %v", n, max/2, max, d1)
+		}
+	}
+}
+
+func pow(x float64, n int) float64 {
+	res := 1.0
+	for i := 0; i < n; i++ {
+		res *= x
+	}
+	return res
+}
+
+func TestTodayClock(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	tests := []struct {
+		now    time.Time
+		h, m   int
+		expect time.Time
+	}{
+		{time.Date(2022, 5, 1, 7, 0, 0, 0, cst), 8, 30, time.Date(2022, 5, 1, 8, 30, 0, 0, cst)},
+		{time.Date(2022, 5, 1, 9, 0, 0, 0, cst), 8, 30, time.Date(2022, 5, 1, 8, 30, 0, 0, cst)},
+		{time.Date(2022, 5, 31, 23, 0, 0, 0, cst), 8, 30, time.Date(2022, 5, 31, 8, 30, 0, 0, cst)},
+		{time.Date(2022, 5, 1, 17, 0, 0, 0, time.UTC), 8, 30, time.Date(2022, 5, 2, 8, 30, 0, 0, cst)}, // 01:00 CST of the next day
+	}
+	for _, tt := range tests {
+		if got := todayClock(tt.now, tt.h, tt.m, cst); !got.Equal(tt.expect) {
+			t.Errorf("now: %v: expect %v, got %v", tt.now, tt.expect, got)
+		}
+	}
+}
+
+type countPolicy struct {
+	max int
+}
+
+func (p countPolicy) Next(n int) (time.Duration, bool) {
+	return time.Millisecond, n < p.max
+}
+
+func TestPunchRetryPolicy(t *testing.T) {
+	now := time.Date(2022, 5, 5, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		deadline *Time
+		attempts int
+		reason   string
+	}{
+		{"policy gives up", countPolicy{2}, nil, 2, "retry policy gave up"},
+		{"max attempts", countPolicy{100}, nil, 5, "maximum attempts"},
+		{"deadline", ConstantBackoff(2 * time.Minute), &Time{Hour: 8, Minute: 1}, 1, "deadline: 08:01 reached after 1 attempt(s)"},
+		{"retry until deadline", ConstantBackoff(time.Minute), &Time{Hour: 8, Minute: 3}, 4, "deadline: 08:03 reached after 4 attempt(s)"},
+		{"deadline passed", ConstantBackoff(time.Minute), &Time{Hour: 7, Minute: 30}, 0, "deadline: 07:30 has passed"},
+		{"deadline in zone", ConstantBackoff(time.Minute), &Time{Hour: 16, Minute: 1, TimeZone: time.FixedZone("CST", 8*3600)}, 2, "deadline: 16:01 reached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			clock := newFakeClock(now)
+			cfg := &Config{
+				Logger:      discardLogger{},
+				MaxAttempts: 5,
+				Time:        Time{TimeZone: time.UTC},
+				Timeout:     time.Second,
+				Retry:       tt.policy,
+				Deadline:    tt.deadline,
+				Clock:       clock,
+				PunchFunc: func(ctx context.Context, account interface{}) error {
+					attempts++
+					return errors.New("temporary")
+				},
+			}
+			done := make(chan error, 1)
+			go func() {
+				_, err := cfg.punch(context.Background(), testAccount("test"))
+				done <- err
+			}()
+			var err error
+		wait:
+			for {
+				select {
+				case err = <-done:
+					break wait
+				default:
+					if !clock.advance() {
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+			if attempts != tt.attempts {
+				t.Errorf("expect %d attempts, got %d", tt.attempts, attempts)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("expect error containing %q, got %v", tt.reason, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule the schedule cannot be parsed
+var ErrInvalidSchedule = errors.New("schedule: invalid format")
+
+// DefaultJitter the default jitter of a schedule
+const DefaultJitter = 5 * time.Minute
+
+// Slot a punch time of a day
+type Slot struct {
+	Hour   int
+	Minute int
+}
+
+// Weekdays a set of weekdays, the zero value means every day
+type Weekdays uint8
+
+// Has report whether the day is in the set
+func (w Weekdays) Has(d time.Weekday) bool {
+	return w == 0 || w&(1<<uint(d)) != 0
+}
+
+var weekdayNames = [...]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule the punch time of each day. The first slot of a day is the
+// punch time, and the later slots are backups which are used only if the
+// punch of the day is not done yet.
+type Schedule struct {
+	Slots    []Slot        // in order, ignored if Cron is set
+	Cron     *Cron         // the slots of a day are the times the cron runs
+	Weekdays Weekdays      // the days to punch, zero for every day
+	Jitter   time.Duration // punch at a random time in [-Jitter, +Jitter) around the slots
+
+	spec string
+}
+
+// ParseSchedule parse a schedule, the syntax is the punch times or a cron
+// expression followed by the options:
+//
+//	08:00
+//	08:00,20:00 days=mon-fri jitter=10m
+//	0 8,20 * * 1-5 jitter=0
+//
+// The times are in `HH:MM` format separated by comma, see Cron for the cron
+// expression. The option days is a comma separated list of weekdays(sun-sat)
+// or ranges of them, and jitter is a duration(default: 5m).
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{Jitter: DefaultJitter, spec: strings.Join(strings.Fields(spec), " ")}
+	var fields []string
+	for _, field := range strings.Fields(spec) {
+		i := strings.IndexByte(field, '=')
+		if i < 0 {
+			fields = append(fields, field)
+			continue
+		}
+		var err error
+		switch key, value := field[:i], field[i+1:]; key {
+		case "days":
+			s.Weekdays, err = parseWeekdays(value)
+		case "jitter":
+			if s.Jitter, err = time.ParseDuration(value); err != nil || s.Jitter < 0 {
+				err = fmt.Errorf("%w: invalid jitter: %s", ErrInvalidSchedule, value)
+			}
+		default:
+			err = fmt.Errorf("%w: unknown option: %s", ErrInvalidSchedule, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	switch len(fields) {
+	case 1:
+		s.Slots, err = parseSlots(fields[0])
+	case len(cronRanges):
+		s.Cron, err = ParseCron(strings.Join(fields, " "))
+	default:
+		err = fmt.Errorf("%w: %q", ErrInvalidSchedule, spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String return the schedule in the syntax of ParseSchedule
+func (s *Schedule) String() string {
+	if s.spec != "" {
+		return s.spec
+	}
+	var str string
+	if s.Cron != nil {
+		str = s.Cron.String()
+	} else {
+		parts := make([]string, 0, len(s.Slots))
+		for _, slot := range s.Slots {
+			parts = append(parts, fmt.Sprintf("%02d:%02d", slot.Hour, slot.Minute))
+		}
+		str = strings.Join(parts, ",")
+	}
+	if s.Weekdays != 0 {
+		days := make([]string, 0, len(weekdayNames))
+		for d, name := range weekdayNames {
+			if s.Weekdays.Has(time.Weekday(d)) {
+				days = append(days, name)
+			}
+		}
+		str += " days=" + strings.Join(days, ",")
+	}
+	if s.Jitter != DefaultJitter {
+		str += " jitter=" + s.Jitter.String()
+	}
+	return str
+}
+
+// slots return the slots of the date in order
+func (s *Schedule) slots(date time.Time) []time.Time {
+	if !s.Weekdays.Has(date.Weekday()) {
+		return nil
+	}
+	if s.Cron != nil {
+		return s.Cron.times(date)
+	}
+	year, month, day := date.Date()
+	times := make([]time.Time, len(s.Slots))
+	for i, slot := range s.Slots {
+		times[i] = time.Date(year, month, day, slot.Hour, slot.Minute, 0, 0, date.Location())
+	}
+	return times
+}
+
+// maxScheduleDays the maximum days to look for the next slot,
+// a cron expression like `0 8 29 2 *` may run once in 8 years
+const maxScheduleDays = 8*366 + 1
+
+// next return the first slot after the time on a day other than the done date,
+// and the punch time of the slot with the jitter. The punch time is kept in
+// the date of the slot so that the punch is not made for another day.
+func (s *Schedule) next(after, done time.Time, r *rand.Rand) (slot, at time.Time, ok bool) {
+	year, month, day := after.Date()
+	doneYear, doneMonth, doneDay := done.In(after.Location()).Date()
+	for i := 0; i < maxScheduleDays; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, after.Location())
+		if !done.IsZero() && date.Year() == doneYear && date.Month() == doneMonth && date.Day() == doneDay {
+			continue
+		}
+		for _, slot = range s.slots(date) {
+			if slot.After(after) {
+				return slot, s.jitter(slot, date, r), true
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// jitter return a random time in [-Jitter, +Jitter) around the slot within the date
+func (s *Schedule) jitter(slot, date time.Time, r *rand.Rand) time.Time {
+	if s.Jitter <= 0 {
+		return slot
+	}
+	t := slot.Add(time.Duration(r.Int63n(int64(2*s.Jitter))) - s.Jitter)
+	end := date.AddDate(0, 0, 1).Add(-time.Second)
+	switch {
+	case t.Before(date):
+		return date
+	case t.After(end):
+		return end
+	}
+	return t
+}
+
+// parseSlots parse the comma separated `HH:MM` times
+func parseSlots(text string) ([]Slot, error) {
+	parts := strings.Split(text, ",")
+	slots := make([]Slot, 0, len(parts))
+	for _, part := range parts {
+		i := strings.IndexByte(part, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: invalid time: %q", ErrInvalidSchedule, part)
+		}
+		hour, err1 := strconv.Atoi(part[:i])
+		minute, err2 := strconv.Atoi(part[i+1:])
+		if err1 != nil || err2 != nil || hour < 0 || hour >= 24 || minute < 0 || minute >= 60 {
+			return nil, fmt.Errorf("%w: invalid time: %q", ErrInvalidSchedule, part)
+		}
+		slots = append(slots, Slot{hour, minute})
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].Hour < slots[j].Hour ||
+			slots[i].Hour == slots[j].Hour && slots[i].Minute < slots[j].Minute
+	})
+	return slots, nil
+}
+
+// parseWeekdays parse the comma separated weekdays or ranges of them, e.g. `mon-fri,sun`
+func parseWeekdays(text string) (w Weekdays, err error) {
+	for _, part := range strings.Split(text, ",") {
+		low, high := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			low, high = part[:i], part[i+1:]
+		}
+		var from, to int
+		if from, err = parseWeekday(low); err != nil {
+			return 0, err
+		}
+		if to, err = parseWeekday(high); err != nil {
+			return 0, err
+		}
+		for d := from; ; d = (d + 1) % 7 { // `fri-mon` wraps around the week
+			w |= 1 << uint(d)
+			if d == to {
+				break
+			}
+		}
+	}
+	return
+}
+
+func parseWeekday(s string) (int, error) {
+	for i, name := range weekdayNames {
+		if strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: invalid weekday: %q", ErrInvalidSchedule, s)
+}
@@ -0,0 +1,176 @@
+package serve
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec     string
+		slots    []Slot
+		weekdays Weekdays
+		jitter   time.Duration
+		cron     bool
+	}{
+		{"8:00", []Slot{{8, 0}}, 0, DefaultJitter, false},
+		{"20:30,08:00  days=mon-fri jitter=10m", []Slot{{8, 0}, {20, 30}}, 0x3e, 10 * time.Minute, false},
+		{"07:00 days=sat,SUN jitter=0", []Slot{{7, 0}}, 0x41, 0, false},
+		{"07:00 days=fri-mon", []Slot{{7, 0}}, 0x63, DefaultJitter, false},
+		{"0 8,20 * * 1-5 jitter=1m", nil, 0, time.Minute, true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.Slots, tt.slots) || s.Weekdays != tt.weekdays ||
+			s.Jitter != tt.jitter || (s.Cron != nil) != tt.cron {
+			t.Errorf("%q: unexpected schedule: %+v", tt.spec, s)
+		}
+	}
+
+	for _, spec := range []string{
+		"", "8", "24:00", "08:60", "08:00 days=mon-foo", "08:00 jitter=-1m", "08:00 jitter=1", "08:00 every=1d",
+		"0 8 * *", "60 8 * * *", "0 8 * * 8", "0 8 5-1 * *", "0 8 * * */0",
+	} {
+		if _, err := ParseSchedule(spec); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("%q: expect ErrInvalidSchedule, got %v", spec, err)
+		}
+	}
+}
+
+func TestScheduleString(t *testing.T) {
+	s, err := ParseSchedule(" 08:00,20:00   days=mon-fri ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.String(); got != "08:00,20:00 days=mon-fri" {
+		t.Errorf("unexpected string: %q", got)
+	}
+	s = &Schedule{Slots: []Slot{{7, 5}}, Weekdays: 1<<time.Saturday | 1<<time.Sunday, Jitter: 0}
+	if got := s.String(); got != "07:05 days=sun,sat jitter=0s" {
+		t.Errorf("unexpected string: %q", got)
+	}
+	if _, err = ParseSchedule(s.String()); err != nil {
+		t.Errorf("the string cannot be parsed: %v", err)
+	}
+}
+
+func TestCronTimes(t *testing.T) {
+	tests := []struct {
+		spec  string
+		date  time.Time
+		times []string
+	}{
+		{"0 8,20 * * *", time.Date(2022, 5, 2, 0, 0, 0, 0, cst), []string{"08:00", "20:00"}},
+		{"*/20 9-10 * * mon-fri", time.Date(2022, 5, 2, 0, 0, 0, 0, cst), []string{"09:00", "09:20", "09:40", "10:00", "10:20", "10:40"}},
+		{"*/20 9-10 * * mon-fri", time.Date(2022, 5, 1, 0, 0, 0, 0, cst), nil}, // Sunday
+		{"30 7 * * 7", time.Date(2022, 5, 1, 0, 0, 0, 0, cst), []string{"07:30"}},
+		{"5/30 7 * may *", time.Date(2022, 5, 1, 0, 0, 0, 0, cst), []string{"07:05", "07:35"}},
+		{"0 8 * jun *", time.Date(2022, 5, 1, 0, 0, 0, 0, cst), nil},
+		// either the day of month or the day of week matches
+		{"0 8 1 * fri", time.Date(2022, 5, 1, 0, 0, 0, 0, cst), []string{"08:00"}},
+		{"0 8 1 * fri", time.Date(2022, 5, 6, 0, 0, 0, 0, cst), []string{"08:00"}},
+		{"0 8 1 * fri", time.Date(2022, 5, 7, 0, 0, 0, 0, cst), nil},
+		// both must match if any of them is `*`
+		{"0 8 1-7 * *", time.Date(2022, 5, 8, 0, 0, 0, 0, cst), nil},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		var times []string
+		for _, v := range c.times(tt.date) {
+			times = append(times, v.Format("15:04"))
+		}
+		if !reflect.DeepEqual(times, tt.times) {
+			t.Errorf("%q on %s: expect %v, got %v", tt.spec, tt.date.Format("2006-01-02"), tt.times, times)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	r := rand.New(fixedSource(0))
+	tests := []struct {
+		spec  string
+		after time.Time
+		done  time.Time
+		want  time.Time
+	}{
+		{"08:00,20:00 jitter=0", time.Date(2022, 5, 2, 7, 0, 0, 0, cst), time.Time{}, time.Date(2022, 5, 2, 8, 0, 0, 0, cst)},
+		{"08:00,20:00 jitter=0", time.Date(2022, 5, 2, 8, 0, 0, 0, cst), time.Time{}, time.Date(2022, 5, 2, 20, 0, 0, 0, cst)},
+		// the backup slot is skipped once the punch of the day is done
+		{"08:00,20:00 jitter=0", time.Date(2022, 5, 2, 8, 0, 0, 0, cst), time.Date(2022, 5, 2, 8, 0, 0, 0, cst), time.Date(2022, 5, 3, 8, 0, 0, 0, cst)},
+		// 2022-05-06 is Friday
+		{"08:00 days=mon-fri jitter=0", time.Date(2022, 5, 6, 9, 0, 0, 0, cst), time.Time{}, time.Date(2022, 5, 9, 8, 0, 0, 0, cst)},
+		{"0 8 29 2 * jitter=0", time.Date(2022, 5, 1, 0, 0, 0, 0, cst), time.Time{}, time.Date(2024, 2, 29, 8, 0, 0, 0, cst)},
+		// the done date is compared in the zone of the schedule
+		{"08:00 jitter=0", time.Date(2022, 5, 2, 7, 0, 0, 0, cst), time.Date(2022, 5, 1, 16, 0, 0, 0, time.UTC), time.Date(2022, 5, 3, 8, 0, 0, 0, cst)},
+		{"08:00", time.Date(2022, 5, 2, 7, 0, 0, 0, cst), time.Time{}, time.Date(2022, 5, 2, 7, 55, 0, 0, cst)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, at, ok := s.next(tt.after, tt.done, r)
+		if !ok || !at.Equal(tt.want) {
+			t.Errorf("%q after %v: expect %v, got %v(ok: %v)", tt.spec, tt.after, tt.want, at, ok)
+		}
+	}
+
+	s, _ := ParseSchedule("0 8 31 2 *")
+	if _, _, ok := s.next(time.Date(2022, 5, 1, 0, 0, 0, 0, cst), time.Time{}, r); ok {
+		t.Error("expect no slot for a cron never running")
+	}
+}
+
+func TestPunchServeSchedule(t *testing.T) {
+	start := time.Date(2022, 5, 5, 7, 0, 0, 0, cst) // Thursday
+	schedule, err := ParseSchedule("08:00,20:00 days=mon-fri jitter=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permanentErr := &testError{"auth rejected", false}
+	var (
+		mux       sync.Mutex
+		scheduled []time.Time
+	)
+	cfg := Config{Time: Time{TimeZone: cst}, Schedule: schedule}
+	cfg.Scheduled = func(account Account, at time.Time) {
+		mux.Lock()
+		scheduled = append(scheduled, at)
+		mux.Unlock()
+	}
+
+	// the punch on start fails, so the slots of Thursday are used, and the
+	// evening one is skipped after the morning one succeeds; the morning
+	// punch of Friday fails, so the evening one is used as a backup
+	s := startServe(cfg, start, permanentErr, nil, permanentErr)
+	want := []time.Time{
+		start,
+		time.Date(2022, 5, 5, 8, 0, 0, 0, cst),
+		time.Date(2022, 5, 6, 8, 0, 0, 0, cst),
+		time.Date(2022, 5, 6, 20, 0, 0, 0, cst),
+		time.Date(2022, 5, 9, 8, 0, 0, 0, cst), // skip the weekend
+		time.Date(2022, 5, 10, 8, 0, 0, 0, cst),
+	}
+	if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+		t.Errorf("expect punches at %v, got %v", want, times)
+	}
+	s.stop(t)
+	// each punch except the one on start is scheduled before
+	mux.Lock()
+	defer mux.Unlock()
+	if len(scheduled) < len(want)-1 || !reflect.DeepEqual(scheduled[:len(want)-1], want[1:]) {
+		t.Errorf("expect scheduled at %v, got %v", want[1:], scheduled)
+	}
+}
@@ -2,16 +2,12 @@ package serve
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
 )
 
-// Sender send a message when punch failed
-type Sender interface {
-	Send(nickName, subject, body string) error
-}
-
 // Logger interface for log
 type Logger interface {
 	Printf(format string, v ...interface{})
@@ -27,14 +23,106 @@ type Time struct {
 
 // Config punch information configuration
 type Config struct {
-	Sender       Sender
-	Logger       Logger
-	MaxAttempts  uint8
-	Time         Time
-	MailNickName string
-	Timeout      time.Duration
-	RetryAfter   time.Duration
-	PunchFunc    func(ctx context.Context, account interface{}) error
+	Notifier    Notifier // notified on failures and skipped punches, nil for no notification
+	Logger      Logger
+	MaxAttempts uint8
+	Time        Time      // the punch time and the time zone of the schedule
+	Schedule    *Schedule // the punch schedule, nil for Time with DefaultJitter
+	Calendar    Calendar  // the punch is skipped on holidays, nil for no holiday
+	Startup     StartupPolicy
+	State       State                               // keep the last success, nil for not keeping
+	Recorder    Recorder                            // record the punch attempts, nil for not recording
+	Scheduled   func(account Account, at time.Time) // called when the next punch is scheduled, may be nil
+	Trigger     <-chan struct{}                     // a manual punch is run on receiving, nil for no manual punch
+	Timeout     time.Duration
+	RetryAfter  time.Duration // the delay between attempts, ignored if Retry is set
+	Retry       RetryPolicy   // nil for ConstantBackoff(RetryAfter)
+	Deadline    *Time         // give up retrying if the next attempt is after the time of the day(fail at once if passed), nil for no deadline
+	PunchFunc   func(ctx context.Context, account interface{}) error
+	Clock       Clock       // nil for SystemClock
+	Rand        rand.Source // the source of the random punch time, nil for a time seeded source
+}
+
+// Status the result of a punch routine
+type Status uint8
+
+const (
+	// StatusSucceeded the punch is submitted successfully
+	StatusSucceeded Status = iota
+	// StatusAlreadyDone the punch has been done before, nothing is submitted
+	StatusAlreadyDone
+	// StatusFailed the punch failed
+	StatusFailed
+	// StatusSkipped the punch is skipped on a holiday
+	StatusSkipped
+)
+
+// MarshalText implement encoding.TextMarshaler
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler
+func (s *Status) UnmarshalText(text []byte) error {
+	for v := StatusSucceeded; v <= StatusSkipped; v++ {
+		if v.String() == string(text) {
+			*s = v
+			return nil
+		}
+	}
+	return fmt.Errorf("status: unknown status: %s", text)
+}
+
+func (s Status) String() string {
+	switch s {
+	case StatusSucceeded:
+		return "succeeded"
+	case StatusAlreadyDone:
+		return "already done"
+	case StatusSkipped:
+		return "skipped"
+	default:
+		return "failed"
+	}
+}
+
+// doner is implemented by the errors returned by PunchFunc when
+// the punch has been done before (e.g. already reported today)
+type doner interface {
+	AlreadyDone() bool
+}
+
+// alreadyDone report whether the error means the punch has been done before
+func alreadyDone(err error) bool {
+	var d doner
+	return errors.As(err, &d) && d.AlreadyDone()
+}
+
+// retryable is implemented by the errors returned by PunchFunc
+// which know whether retrying later may succeed
+type retryable interface {
+	Retryable() bool
+}
+
+// permanent report whether the error is a permanent failure, errors
+// not implementing Retryable are treated as temporary
+func permanent(err error) bool {
+	var r retryable
+	return errors.As(err, &r) && !r.Retryable()
+}
+
+// kinded is implemented by the errors returned by PunchFunc which know their kinds
+type kinded interface {
+	ErrorKind() string
+}
+
+// errorKind return the kind of the error, "unknown" if the kind is not provided
+func errorKind(err error) string {
+	var k kinded
+	if errors.As(err, &k) {
+		return k.ErrorKind()
+	}
+	return "unknown"
 }
 
 // Account interface for get account name
@@ -44,90 +132,323 @@ type Account interface {
 }
 
 // PunchServe universal punch service.
-// When it is called, it will call the punch function immediately,
-// and then call the punch function daily.
+// When it is called, it will call the punch function immediately
+// according to the startup policy, and then call the punch function
+// at the slots of the schedule. The slots of a day are skipped once
+// the punch of the day is done.
 func (cfg Config) PunchServe(ctx context.Context, account Account) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	cfg.Logger.Print("Punch on a 24-hour cycle\n")
+	schedule := cfg.schedule()
+	cfg.Logger.Printf("Punch schedule: %s\n", schedule)
 
-	var nextTime time.Time
-	{
-		year, month, day := time.Now().In(cfg.Time.TimeZone).Date()
-		nextTime = time.Date(year, month, day+1, // next day
-			cfg.Time.Hour,
-			cfg.Time.Minute-5, // rand in [-5, +5) minutes
-			0, 0, cfg.Time.TimeZone,
-		)
+	clock := cfg.clock()
+	source := cfg.Rand
+	if source == nil {
+		source = rand.NewSource(clock.Now().UnixNano())
 	}
+	r := rand.New(source)
 
-	r := rand.New(rand.NewSource(time.Now().Unix()))
-
-	timer := time.NewTimer(time.Until(nextTime) + time.Duration(r.Int63())%(time.Minute*10))
+	var (
+		timer Timer
+		slot  = clock.Now().In(cfg.Time.TimeZone) // the current slot
+		done  time.Time                           // the slot when the punch is done last time
+	)
+	if last, ok := cfg.lastSuccess(account); ok && DateOf(last.In(slot.Location())) == DateOf(slot) {
+		done = slot
+	}
+	if !cfg.startup(schedule, slot, done) {
+		cfg.Logger.Printf("Punch on start skipped(startup policy: %s)\n", cfg.Startup)
+	} else if cfg.routine(ctx, account, slot) {
+		done = slot
+	}
 	for {
-		cfg.Logger.Print("Start punch routine\n")
-		if err := cfg.punch(ctx, account); err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		cfg.Logger.Print("Punch finished\n")
 
+		next, at, ok := schedule.next(slot, done, r)
+		if !ok {
+			return fmt.Errorf("no punch time in the schedule: %s", schedule)
+		}
+		cfg.Logger.Printf("Next punch at %s\n", at.Format("2006-01-02 15:04:05"))
+		if cfg.Scheduled != nil {
+			cfg.Scheduled(account, at)
+		}
+
+		delay := at.Sub(clock.Now())
+		if timer == nil {
+			timer = clock.NewTimer(delay)
+		} else {
+			timer.Reset(delay)
+		}
 		select {
-		case <-timer.C:
+		case <-timer.C():
+		case <-cfg.Trigger:
+			stopTimer(timer)
+			// the scheduled slot is kept unless the manual punch is done
+			cfg.Logger.Print("Manual punch requested\n")
+			now := clock.Now().In(cfg.Time.TimeZone)
+			if cfg.run(ctx, account) {
+				done = now
+			}
+			continue
 		case <-ctx.Done():
 			timer.Stop()
 			return ctx.Err()
 		}
-		nextTime = nextTime.AddDate(0, 0, 1)
-		timer.Reset(time.Until(nextTime) + time.Duration(r.Int63())%(time.Minute*10))
+
+		slot = next
+		if cfg.routine(ctx, account, slot) {
+			done = slot
+		}
+	}
+}
+
+// routine run the punch routine of the slot, or skip it on a holiday.
+// It returns whether the punch of the day is done.
+func (cfg *Config) routine(ctx context.Context, account Account, slot time.Time) bool {
+	if name, ok := cfg.holiday(slot); ok {
+		cfg.Logger.Printf("Punch skipped: holiday %s\n", name)
+		cfg.recordSkipped(account, name)
+		cfg.notifySkipped(account, name)
+		return true
 	}
+	return cfg.run(ctx, account)
+}
+
+// run run the punch routine, it returns whether the punch of the day is done
+func (cfg *Config) run(ctx context.Context, account Account) bool {
+	cfg.Logger.Print("Start punch routine\n")
+	status, err := cfg.punch(ctx, account)
+	switch {
+	case ctx.Err() != nil:
+		return false
+	case err != nil:
+		cfg.Logger.Printf("Punch failed, err: %s\n", err.Error())
+		return false
+	case status == StatusAlreadyDone:
+		cfg.Logger.Print("Punch skipped: already done\n")
+	default:
+		cfg.Logger.Print("Punch finished\n")
+	}
+	cfg.setLastSuccess(account)
+	return true
+}
+
+// startup report whether to punch on start according to the startup policy
+func (cfg *Config) startup(schedule *Schedule, now, done time.Time) bool {
+	slots := schedule.slots(now) // no slot on the days excluded by the schedule
+	switch cfg.Startup {
+	case StartupIfNotDone:
+		return done.IsZero() && len(slots) != 0
+	case StartupNever:
+		// catch up if a slot of today is missed
+		return done.IsZero() && len(slots) != 0 && !slots[0].After(now)
+	default:
+		return true
+	}
+}
+
+// lastSuccess return the time of the last success of the account
+func (cfg *Config) lastSuccess(account Account) (time.Time, bool) {
+	if cfg.State == nil {
+		return time.Time{}, false
+	}
+	return cfg.State.LastSuccess(account.Name())
+}
+
+func (cfg *Config) setLastSuccess(account Account) {
+	if cfg.State == nil {
+		return
+	}
+	if err := cfg.State.SetLastSuccess(account.Name(), cfg.clock().Now()); err != nil {
+		cfg.Logger.Printf("Save state failed, err: %s\n", err.Error())
+	}
+}
+
+func (cfg *Config) schedule() *Schedule {
+	if cfg.Schedule != nil {
+		return cfg.Schedule
+	}
+	return &Schedule{
+		Slots:  []Slot{{cfg.Time.Hour, cfg.Time.Minute}},
+		Jitter: DefaultJitter,
+	}
+}
+
+// holiday report whether the date of the slot is a holiday, and the name of the holiday
+func (cfg *Config) holiday(slot time.Time) (string, bool) {
+	if cfg.Calendar == nil {
+		return "", false
+	}
+	return cfg.Calendar.Holiday(DateOf(slot))
+}
+
+// stopTimer stop the timer and drain its channel, so that it can be reset
+func stopTimer(timer Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C():
+		default:
+		}
+	}
+}
+
+func (cfg *Config) clock() Clock {
+	if cfg.Clock == nil {
+		return SystemClock{}
+	}
+	return cfg.Clock
 }
 
 func (cfg *Config) punchWithTimeout(ctx context.Context, account Account) error {
+	return cfg.callWithTimeout(ctx, cfg.PunchFunc, account)
+}
+
+func (cfg *Config) callWithTimeout(ctx context.Context, f func(ctx context.Context, account interface{}) error, account Account) error {
 	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
 	defer cancel()
-	return cfg.PunchFunc(ctx, account)
+	return f(ctx, account)
+}
+
+func (cfg *Config) retryPolicy() RetryPolicy {
+	if cfg.Retry == nil {
+		return ConstantBackoff(cfg.RetryAfter)
+	}
+	return cfg.Retry
+}
+
+// Confirm call the confirm function(e.g. verify the password before serving) until
+// it succeeds, the errors are retried by the retry policy until a permanent one, and
+// a final failure is notified on the permanent error or if the retry policy gives up
+func (cfg *Config) Confirm(ctx context.Context, account Account, confirm func(ctx context.Context, account interface{}) error) error {
+	policy := cfg.retryPolicy()
+	clock := cfg.clock()
+	var timer Timer
+	for attempt := 1; ; attempt++ {
+		err := cfg.callWithTimeout(ctx, confirm, account)
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case permanent(err):
+			// e.g. a wrong password, notify it at once
+			cfg.notifyFailure(account, attempt, err)
+			return err
+		}
+		delay, ok := policy.Next(attempt)
+		if !ok {
+			err = fmt.Errorf("retry policy gave up after %d attempt(s) with error: %w", attempt, err)
+			cfg.notifyFailure(account, attempt, err)
+			return err
+		}
+		cfg.Logger.Printf("Confirm failed %d time(s), retry after %v, err: %s\n", attempt, delay, err.Error())
+
+		if timer == nil {
+			timer = clock.NewTimer(delay)
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
 }
 
-// punch keep trying until successed or max attempts reached
-func (cfg *Config) punch(ctx context.Context, account Account) (err error) {
-	var timer *time.Timer
-	for punchCount := uint8(1); true; punchCount++ {
+// punch keep trying until successed, already done, or the retry policy,
+// the deadline or the max attempts stops it
+func (cfg *Config) punch(ctx context.Context, account Account) (status Status, err error) {
+	policy := cfg.retryPolicy()
+	clock := cfg.clock()
+	var deadline time.Time
+	if cfg.Deadline != nil {
+		now := clock.Now()
+		deadline = todayClock(now, cfg.Deadline.Hour, cfg.Deadline.Minute, cfg.deadlineZone())
+		if !now.Before(deadline) {
+			err = fmt.Errorf("deadline: %s has passed", deadline.Format("15:04"))
+			cfg.Logger.Printf("Skip punch, err: %s\n", err.Error())
+			cfg.notifyFailure(account, 0, err)
+			return StatusFailed, err
+		}
+	}
+
+	var (
+		timer      Timer
+		punchCount uint8
+	)
+	for punchCount = 1; true; punchCount++ {
 		cfg.Logger.Print("Start punch\n")
+		start := clock.Now()
 		err = cfg.punchWithTimeout(ctx, account)
+		if err != context.Canceled {
+			cfg.record(account, start, int(punchCount), err)
+		}
 
 		// error handling
-		if err == nil || err == context.Canceled {
-			return
+		if alreadyDone(err) {
+			cfg.notifySuccess(account, int(punchCount), StatusAlreadyDone)
+			return StatusAlreadyDone, nil
+		}
+		if err == nil {
+			cfg.notifySuccess(account, int(punchCount), StatusSucceeded)
+			return StatusSucceeded, nil
+		}
+		if err == context.Canceled {
+			return StatusFailed, err
 		}
 
+		if permanent(err) {
+			cfg.Logger.Printf("Tried %d times, stop retrying on permanent failure(%s), err: %s\n", punchCount, errorKind(err), err.Error())
+			cfg.notifyFailure(account, int(punchCount), err)
+			return StatusFailed, fmt.Errorf("permanent failure after %d attempt(s): %w", punchCount, err)
+		}
 		if punchCount >= cfg.MaxAttempts {
+			err = fmt.Errorf("maximum attempts: %d reached with error: %w", cfg.MaxAttempts, err)
 			break
 		}
-		cfg.Logger.Printf("Tried %d times, retry after %v, err: %s\n", punchCount, cfg.RetryAfter, err.Error())
+		delay, ok := policy.Next(int(punchCount))
+		if !ok {
+			err = fmt.Errorf("retry policy gave up after %d attempt(s) with error: %w", punchCount, err)
+			break
+		}
+		if !deadline.IsZero() && clock.Now().Add(delay).After(deadline) {
+			err = fmt.Errorf("deadline: %s reached after %d attempt(s) with error: %w",
+				deadline.Format("15:04"), punchCount, err)
+			break
+		}
+		cfg.Logger.Printf("Tried %d times, retry after %v, err: %s\n", punchCount, delay, err.Error())
+		if punchCount == 1 {
+			cfg.notifyFirstFailure(account, err)
+		}
 
 		// waiting
 		if timer == nil {
-			timer = time.NewTimer(cfg.RetryAfter)
+			timer = clock.NewTimer(delay)
 		} else {
-			timer.Reset(cfg.RetryAfter)
+			timer.Reset(delay)
 		}
 		select {
-		case <-timer.C: // try again after cfg.RetryAfter.
+		case <-timer.C(): // try again after the delay.
 		case <-ctx.Done():
 			timer.Stop()
-			return ctx.Err()
+			return StatusFailed, ctx.Err()
 		}
 	}
 	// error handling
-	if cfg.Sender != nil {
-		err := cfg.Sender.Send(cfg.MailNickName,
-			fmt.Sprintf("打卡状态推送-%s", time.Now().In(cfg.Time.TimeZone).Format("2006-01-02")),
-			fmt.Sprintf("账户: %s 打卡失败(err: %s)", account.Name(), err.Error()))
-		if err != nil {
-			cfg.Logger.Printf("Send message failed, err: %s\n", err.Error())
-		}
+	cfg.notifyFailure(account, int(punchCount), err)
+	return StatusFailed, err
+}
+
+func (cfg *Config) deadlineZone() *time.Location {
+	if cfg.Deadline.TimeZone != nil {
+		return cfg.Deadline.TimeZone
 	}
-	return fmt.Errorf("maximum attempts: %d reached with error: %w", cfg.MaxAttempts, err)
+	return cfg.Time.TimeZone
 }
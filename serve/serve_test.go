@@ -0,0 +1,507 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testAccount string
+
+func (a testAccount) Name() string { return string(a) }
+
+type testError struct {
+	kind      string
+	retryable bool
+}
+
+func (e *testError) Error() string     { return e.kind + " error" }
+func (e *testError) Retryable() bool   { return e.retryable }
+func (e *testError) ErrorKind() string { return e.kind }
+
+type doneError struct{}
+
+func (doneError) Error() string     { return "already done" }
+func (doneError) AlreadyDone() bool { return true }
+
+type testNotifier struct {
+	mux    sync.Mutex
+	events []Event
+}
+
+func (n *testNotifier) Notify(e Event) error {
+	n.mux.Lock()
+	n.events = append(n.events, e)
+	n.mux.Unlock()
+	return nil
+}
+
+// bodies return the bodies of the events of the type
+func (n *testNotifier) bodies(typ EventType) []string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	var bodies []string
+	for _, e := range n.events {
+		if e.Type == typ {
+			bodies = append(bodies, e.Body)
+		}
+	}
+	return bodies
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+func (discardLogger) Print(v ...interface{})                 {}
+
+func TestPunchRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error // errors returned by the punch function in order, nil after the last one
+		status   Status
+		attempts int
+		failed   bool        // whether punch returns an error
+		events   []EventType // the notified events in order
+	}{
+		{"success", nil, StatusSucceeded, 1, false, []EventType{EventSuccess}},
+		{"already done", []error{doneError{}}, StatusAlreadyDone, 1, false, []EventType{EventSuccess}},
+		{"recovered", []error{errors.New("timeout"), &testError{"network", true}}, StatusSucceeded, 3, false, []EventType{EventFirstFailure, EventRecovered}},
+		{"permanent at first", []error{&testError{"auth rejected", false}}, StatusFailed, 1, true, []EventType{EventFinalFailure}},
+		{"permanent", []error{&testError{"network", true}, &testError{"auth rejected", false}}, StatusFailed, 2, true, []EventType{EventFirstFailure, EventFinalFailure}},
+		{"max attempts", []error{&testError{"captcha", true}, &testError{"captcha", true}, &testError{"captcha", true}, &testError{"captcha", true}}, StatusFailed, 3, true, []EventType{EventFirstFailure, EventFinalFailure}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			notifier := &testNotifier{}
+			cfg := &Config{
+				Notifier:    notifier,
+				Logger:      discardLogger{},
+				MaxAttempts: 3,
+				Time:        Time{TimeZone: time.UTC},
+				Timeout:     time.Second,
+				RetryAfter:  time.Millisecond,
+				PunchFunc: func(ctx context.Context, account interface{}) error {
+					attempts++
+					if attempts <= len(tt.errs) {
+						return tt.errs[attempts-1]
+					}
+					return nil
+				},
+			}
+			status, err := cfg.punch(context.Background(), testAccount("test"))
+			if status != tt.status {
+				t.Errorf("expect status %v, got %v", tt.status, status)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("expect %d attempts, got %d", tt.attempts, attempts)
+			}
+			if (err != nil) != tt.failed {
+				t.Fatalf("expect failed: %v, got err: %v", tt.failed, err)
+			}
+			var events []EventType
+			for _, e := range notifier.events {
+				events = append(events, e.Type)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Fatalf("expect events %v, got %v", tt.events, events)
+			}
+			last := notifier.events[len(notifier.events)-1]
+			if last.Account != "test" || last.Attempt != attempts || last.ID == "" {
+				t.Errorf("unexpected event: %+v", last)
+			}
+			if tt.failed {
+				kind := tt.errs[attempts-1].(*testError).kind
+				if last.Kind != kind || !strings.Contains(last.Body, kind) {
+					t.Errorf("expect the error kind %q in the event, got %+v", kind, last)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	start := time.Date(2022, 5, 5, 7, 0, 0, 0, cst)
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		errs   []error
+		times  []time.Time // the time of each attempt
+		failed bool
+		events []EventType
+	}{
+		{"success", nil, nil, []time.Time{start}, false, nil},
+		{"transient", NewExponentialBackoff(time.Minute, 0, 2, 0, nil), []error{errors.New("timeout"), &testError{"captcha", true}},
+			[]time.Time{start, start.Add(time.Minute), start.Add(3 * time.Minute)}, false, nil},
+		{"policy gives up", countPolicy{2}, []error{&testError{"network", true}, &testError{"network", true}},
+			[]time.Time{start, start.Add(time.Millisecond)}, true, []EventType{EventFinalFailure}},
+		{"permanent", nil, []error{&testError{"auth rejected", false}}, []time.Time{start}, true, []EventType{EventFinalFailure}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(start)
+			notifier := &testNotifier{}
+			var times []time.Time
+			confirm := func(ctx context.Context, account interface{}) error {
+				times = append(times, clock.Now())
+				if len(times) <= len(tt.errs) {
+					return tt.errs[len(times)-1]
+				}
+				return nil
+			}
+			cfg := &Config{
+				Notifier: notifier,
+				Logger:   discardLogger{},
+				Time:     Time{TimeZone: cst},
+				Timeout:  time.Second,
+				Retry:    tt.policy,
+				Clock:    clock,
+			}
+			done := make(chan error, 1)
+			go func() { done <- cfg.Confirm(context.Background(), testAccount("test"), confirm) }()
+			var err error
+		wait:
+			for {
+				select {
+				case err = <-done:
+					break wait
+				default:
+					if !clock.advance() {
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+			if (err != nil) != tt.failed {
+				t.Fatalf("expect failed: %v, got err: %v", tt.failed, err)
+			}
+			if !reflect.DeepEqual(times, tt.times) {
+				t.Errorf("expect attempts at %v, got %v", tt.times, times)
+			}
+			var events []EventType
+			for _, e := range notifier.events {
+				events = append(events, e.Type)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Fatalf("expect events %v, got %v", tt.events, events)
+			}
+			if tt.failed {
+				kind := tt.errs[len(tt.errs)-1].(*testError).kind
+				if e := notifier.events[0]; e.Kind != kind || e.Attempt != len(times) || !strings.Contains(e.Body, kind) {
+					t.Errorf("expect the error kind %q in the event, got %+v", kind, e)
+				}
+			}
+		})
+	}
+
+	// canceled while waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := newFakeClock(start)
+	cfg := &Config{Logger: discardLogger{}, Timeout: time.Second, RetryAfter: time.Minute, Clock: clock}
+	done := make(chan error, 1)
+	go func() {
+		done <- cfg.Confirm(ctx, testAccount("test"), func(ctx context.Context, account interface{}) error {
+			return errors.New("timeout")
+		})
+	}()
+	clock.next(t)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expect context.Canceled, got %v", err)
+	}
+}
+
+var cst = time.FixedZone("CST", 8*3600)
+
+// serveRun a PunchServe running with a fake clock
+type serveRun struct {
+	clock   *fakeClock
+	punched chan time.Time // the time of each attempt
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+// startServe start PunchServe at the time, the punch function returns the errors in order,
+// and nil after the last one
+func startServe(cfg Config, now time.Time, errs ...error) *serveRun {
+	s := &serveRun{
+		clock:   newFakeClock(now),
+		punched: make(chan time.Time, 100),
+		done:    make(chan error, 1),
+	}
+	attempts := 0
+	cfg.Clock = s.clock
+	cfg.Logger = discardLogger{}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 16
+	}
+	cfg.PunchFunc = func(ctx context.Context, account interface{}) error {
+		s.punched <- s.clock.Now()
+		attempts++
+		if attempts <= len(errs) {
+			return errs[attempts-1]
+		}
+		return nil
+	}
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go func() { s.done <- cfg.PunchServe(ctx, testAccount("test")) }()
+	return s
+}
+
+// punches return the time of the next n attempts, the clock is advanced if no attempt is made
+func (s *serveRun) punches(t *testing.T, n int) []time.Time {
+	t.Helper()
+	times := make([]time.Time, 0, n)
+	for start := time.Now(); len(times) < n; {
+		select {
+		case p := <-s.punched:
+			times = append(times, p)
+		case err := <-s.done:
+			if len(s.punched) != 0 { // collect the attempts first
+				s.done <- err
+				continue
+			}
+			t.Fatalf("PunchServe returned after %d attempt(s), err: %v", len(times), err)
+		default:
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("expect %d attempts, got %d", n, len(times))
+			}
+			if !s.clock.advance() {
+				time.Sleep(time.Millisecond) // PunchServe is busy
+			}
+		}
+	}
+	return times
+}
+
+// stop cancel PunchServe while it is waiting, and check that it returns
+func (s *serveRun) stop(t *testing.T) {
+	t.Helper()
+	s.clock.next(t)
+	s.cancel()
+	select {
+	case err := <-s.done:
+		if err != context.Canceled {
+			t.Errorf("expect context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PunchServe doesn't return after canceled")
+	}
+	if n := s.clock.active(); n != 0 {
+		t.Errorf("expect the timers to be stopped, %d still active", n)
+	}
+}
+
+func TestPunchServeDaily(t *testing.T) {
+	start := time.Date(2022, 5, 1, 10, 0, 0, 0, cst)
+	cfg := Config{Time: Time{Hour: 8, Minute: 0, TimeZone: cst}}
+
+	run := func() []time.Time {
+		cfg.Rand = rand.NewSource(1)
+		s := startServe(cfg, start)
+		defer s.stop(t)
+		return s.punches(t, 8)
+	}
+	times := run()
+	if !times[0].Equal(start) {
+		t.Errorf("expect the first punch at start %v, got %v", start, times[0])
+	}
+	for i, p := range times[1:] {
+		slot := time.Date(2022, 5, 2+i, 8, 0, 0, 0, cst)
+		if p.Before(slot.Add(-5*time.Minute)) || !p.Before(slot.Add(5*time.Minute)) {
+			t.Errorf("expect punch #%d in [-5, +5) minutes around %v, got %v", i+2, slot, p)
+		}
+	}
+
+	if again := run(); !reflect.DeepEqual(again, times) {
+		t.Errorf("expect the same punch time with the same seed, got %v and %v", times, again)
+	}
+}
+
+func TestPunchServeTimeZoneEdge(t *testing.T) {
+	maxJitter := fixedSource(10*time.Minute - 1)
+	tests := []struct {
+		name   string
+		time   Time
+		start  time.Time
+		source rand.Source
+		want   []time.Time // the punch time after the first one
+	}{
+		{
+			"window before midnight", Time{0, 2, cst}, time.Date(2022, 5, 1, 23, 59, 0, 0, cst), fixedSource(0),
+			[]time.Time{time.Date(2022, 5, 2, 0, 0, 0, 0, cst), time.Date(2022, 5, 3, 0, 0, 0, 0, cst)},
+		},
+		{
+			"window across midnight", Time{0, 2, cst}, time.Date(2022, 5, 1, 23, 59, 0, 0, cst), maxJitter,
+			[]time.Time{time.Date(2022, 5, 2, 0, 6, 59, 999999999, cst), time.Date(2022, 5, 3, 0, 6, 59, 999999999, cst)},
+		},
+		{
+			"window after midnight", Time{23, 58, cst}, time.Date(2022, 5, 1, 0, 1, 0, 0, cst), maxJitter,
+			[]time.Time{time.Date(2022, 5, 2, 23, 59, 59, 0, cst), time.Date(2022, 5, 3, 23, 59, 59, 0, cst)},
+		},
+		{
+			"month end", Time{8, 0, cst}, time.Date(2022, 2, 28, 12, 0, 0, 0, cst), fixedSource(5 * time.Minute),
+			[]time.Time{time.Date(2022, 3, 1, 8, 0, 0, 0, cst), time.Date(2022, 3, 2, 8, 0, 0, 0, cst)},
+		},
+		{
+			// 16:30 UTC is 00:30 of the next day in CST
+			"clock in another zone", Time{8, 0, cst}, time.Date(2022, 5, 1, 16, 30, 0, 0, time.UTC), fixedSource(5 * time.Minute),
+			[]time.Time{time.Date(2022, 5, 3, 8, 0, 0, 0, cst), time.Date(2022, 5, 4, 8, 0, 0, 0, cst)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startServe(Config{Time: tt.time, Rand: tt.source}, tt.start)
+			defer s.stop(t)
+			times := s.punches(t, len(tt.want)+1)
+			for i, want := range tt.want {
+				if !times[i+1].Equal(want) {
+					t.Errorf("expect punch #%d at %v, got %v", i+2, want, times[i+1].In(cst))
+				}
+			}
+		})
+	}
+}
+
+func TestPunchServeRetry(t *testing.T) {
+	start := time.Date(2022, 5, 1, 8, 0, 0, 0, cst)
+	temporary := &testError{"network", true}
+
+	t.Run("recovered", func(t *testing.T) {
+		cfg := Config{Time: Time{8, 0, cst}, Rand: fixedSource(5 * time.Minute), RetryAfter: 10 * time.Minute}
+		s := startServe(cfg, start, temporary, temporary)
+		defer s.stop(t)
+		want := []time.Time{start, start.Add(10 * time.Minute), start.Add(20 * time.Minute), start.AddDate(0, 0, 1)}
+		if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+			t.Errorf("expect punches at %v, got %v", want, times)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		notifier := &testNotifier{}
+		cfg := Config{
+			Time:       Time{8, 0, cst},
+			Rand:       fixedSource(5 * time.Minute),
+			Notifier:   notifier,
+			Retry:      NewExponentialBackoff(10*time.Minute, 0, 2, 0, nil),
+			Deadline:   &Time{Hour: 8, Minute: 25},
+			RetryAfter: time.Hour,
+		}
+		s := startServe(cfg, start, temporary, temporary, temporary)
+		defer s.stop(t)
+		want := []time.Time{
+			start, start.Add(10 * time.Minute), // the third attempt would be at 08:30, give up
+			start.AddDate(0, 0, 1), start.AddDate(0, 0, 1).Add(10 * time.Minute), // the service keeps running
+		}
+		if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+			t.Errorf("expect punches at %v, got %v", want, times)
+		}
+		if len(notifier.bodies(EventFinalFailure)) != 1 {
+			t.Errorf("expect the failure of the first day notified, got messages: %v", notifier.bodies(EventFinalFailure))
+		}
+	})
+
+	t.Run("canceled while retrying", func(t *testing.T) {
+		s := startServe(Config{Time: Time{8, 0, cst}, RetryAfter: time.Hour}, start, temporary)
+		s.punches(t, 1)
+		s.stop(t)
+	})
+}
+
+func TestPunchServeStartupOffDay(t *testing.T) {
+	weekdays, err := ParseSchedule("08:00 days=mon-fri jitter=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cron, err := ParseSchedule("0 8 * * 1-5 jitter=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, err := ParseSchedule("08:00 jitter=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saturday := time.Date(2022, 5, 7, 10, 0, 0, 0, cst)
+	monday := time.Date(2022, 5, 9, 8, 0, 0, 0, cst)
+	tests := []struct {
+		name     string
+		schedule *Schedule
+		calendar Calendar
+		start    time.Time
+		first    time.Time
+	}{
+		{"weekend", weekdays, nil, saturday, monday},
+		{"cron weekend", cron, nil, saturday, monday},
+		{"holiday", daily, Holidays{{Start: Date{2022, 5, 5}, Name: "vacation"}},
+			time.Date(2022, 5, 5, 10, 0, 0, 0, cst), time.Date(2022, 5, 6, 8, 0, 0, 0, cst)},
+		{"working day", weekdays, nil, saturday.AddDate(0, 0, -1), saturday.AddDate(0, 0, -1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Time:     Time{TimeZone: cst},
+				Schedule: tt.schedule,
+				Calendar: tt.calendar,
+				Startup:  StartupIfNotDone,
+				State:    NewStateStore(""),
+			}
+			s := startServe(cfg, tt.start)
+			defer s.stop(t)
+			if times := s.punches(t, 1); !times[0].Equal(tt.first) {
+				t.Errorf("expect the first punch at %v, got %v", tt.first, times[0])
+			}
+		})
+	}
+}
+
+func TestPunchServeTrigger(t *testing.T) {
+	start := time.Date(2022, 5, 5, 7, 0, 0, 0, cst)
+	trigger := make(chan struct{})
+	cfg := Config{
+		Time:     Time{8, 0, cst},
+		Rand:     fixedSource(5 * time.Minute),
+		Trigger:  trigger,
+		Calendar: Holidays{{Start: Date{2022, 5, 5}, Name: "vacation"}},
+	}
+
+	// the punch on start is skipped on a holiday, but the manual one is not
+	s := startServe(cfg, start)
+	defer s.stop(t)
+	s.clock.next(t)
+	trigger <- struct{}{}
+	select {
+	case p := <-s.punched:
+		if !p.Equal(start) {
+			t.Errorf("expect the manual punch at %v, got %v", start, p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no manual punch")
+	}
+	want := []time.Time{time.Date(2022, 5, 6, 8, 0, 0, 0, cst)}
+	if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+		t.Errorf("expect punches at %v, got %v", want, times)
+	}
+
+	// the scheduled slot is kept if the manual punch fails
+	trigger = make(chan struct{})
+	cfg.Trigger, cfg.Calendar = trigger, nil
+	s = startServe(cfg, start, &testError{"auth rejected", false}, &testError{"auth rejected", false})
+	defer s.stop(t)
+	s.punches(t, 1)
+	s.clock.next(t)
+	trigger <- struct{}{}
+	<-s.punched
+	want = []time.Time{time.Date(2022, 5, 5, 8, 0, 0, 0, cst)}
+	if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+		t.Errorf("expect punches at %v, got %v", want, times)
+	}
+}
@@ -0,0 +1,132 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StartupPolicy decide whether to punch immediately when PunchServe is called
+type StartupPolicy uint8
+
+const (
+	// StartupAlways punch immediately
+	StartupAlways StartupPolicy = iota
+	// StartupIfNotDone punch immediately unless the last success is today
+	StartupIfNotDone
+	// StartupNever wait for the slots of the schedule, but catch up
+	// the missed slots of today if the punch of today is not done
+	StartupNever
+)
+
+var startupPolicyNames = [...]string{"always", "if-not-done", "never"}
+
+// ParseStartupPolicy parse a startup policy: always, if-not-done or never
+func ParseStartupPolicy(s string) (StartupPolicy, error) {
+	for i, name := range startupPolicyNames {
+		if s == name {
+			return StartupPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("startup: unknown policy: %s", s)
+}
+
+func (p StartupPolicy) String() string {
+	if int(p) < len(startupPolicyNames) {
+		return startupPolicyNames[p]
+	}
+	return "unknown"
+}
+
+// MarshalText implement encoding.TextMarshaler
+func (p StartupPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler
+func (p *StartupPolicy) UnmarshalText(text []byte) error {
+	v, err := ParseStartupPolicy(string(text))
+	if err == nil {
+		*p = v
+	}
+	return err
+}
+
+// State keep the time of the last success of the accounts
+type State interface {
+	LastSuccess(account string) (t time.Time, ok bool)
+	SetLastSuccess(account string, t time.Time) error
+}
+
+// StateStore a State kept in memory, and persisted in a directory if set
+type StateStore struct {
+	dir  string
+	mux  sync.Mutex
+	last map[string]time.Time
+}
+
+type accountState struct {
+	LastSuccess time.Time `json:"lastSuccess"`
+}
+
+// NewStateStore return a state store, the states are persisted in dir
+// with 0600 permissions. If dir is empty, the states are kept in memory only.
+func NewStateStore(dir string) *StateStore {
+	return &StateStore{
+		dir:  dir,
+		last: make(map[string]time.Time),
+	}
+}
+
+// LastSuccess implement State
+func (s *StateStore) LastSuccess(account string) (time.Time, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if t, ok := s.last[account]; ok {
+		return t, true
+	}
+	if s.dir == "" {
+		return time.Time{}, false
+	}
+	f, err := os.Open(s.filename(account))
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	var state accountState
+	if json.NewDecoder(f).Decode(&state) != nil || state.LastSuccess.IsZero() {
+		return time.Time{}, false
+	}
+	s.last[account] = state.LastSuccess
+	return state.LastSuccess, true
+}
+
+// SetLastSuccess implement State
+func (s *StateStore) SetLastSuccess(account string, t time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.last[account] = t
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.filename(account), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(accountState{LastSuccess: t})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *StateStore) filename(account string) string {
+	return filepath.Join(s.dir, url.PathEscape(account)+".state.json")
+}
@@ -0,0 +1,97 @@
+package serve
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	last := time.Date(2022, 5, 1, 8, 0, 0, 0, cst)
+
+	s := NewStateStore(dir)
+	if _, ok := s.LastSuccess("a/b"); ok {
+		t.Fatal("expect no state")
+	}
+	if err := s.SetLastSuccess("a/b", last); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a%2Fb.state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expect permissions 0600, got %o", perm)
+	}
+
+	// a new store loads the persisted state
+	if got, ok := NewStateStore(dir).LastSuccess("a/b"); !ok || !got.Equal(last) {
+		t.Errorf("expect %v, got %v(ok: %v)", last, got, ok)
+	}
+
+	s = NewStateStore("")
+	if err = s.SetLastSuccess("test", last); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.LastSuccess("test"); !ok || !got.Equal(last) {
+		t.Errorf("expect %v in memory, got %v(ok: %v)", last, got, ok)
+	}
+}
+
+func TestParseStartupPolicy(t *testing.T) {
+	for _, p := range []StartupPolicy{StartupAlways, StartupIfNotDone, StartupNever} {
+		if got, err := ParseStartupPolicy(p.String()); err != nil || got != p {
+			t.Errorf("%s: got %v, err: %v", p, got, err)
+		}
+	}
+	if _, err := ParseStartupPolicy("sometimes"); err == nil {
+		t.Error("expect an error for unknown policy")
+	}
+}
+
+func TestPunchServeStartup(t *testing.T) {
+	yesterday := time.Date(2022, 5, 4, 8, 0, 0, 0, cst)
+	today := time.Date(2022, 5, 5, 8, 0, 0, 0, cst)
+	tomorrow := time.Date(2022, 5, 6, 8, 0, 0, 0, cst)
+	tests := []struct {
+		name   string
+		policy StartupPolicy
+		start  time.Time
+		last   time.Time // zero for no state
+		first  time.Time // the first punch
+	}{
+		{"always", StartupAlways, today.Add(2 * time.Hour), today, today.Add(2 * time.Hour)},
+		{"if not done: done today", StartupIfNotDone, today.Add(2 * time.Hour), today, tomorrow},
+		{"if not done: done yesterday", StartupIfNotDone, today.Add(-time.Hour), yesterday, today.Add(-time.Hour)},
+		{"if not done: no state", StartupIfNotDone, today.Add(-time.Hour), time.Time{}, today.Add(-time.Hour)},
+		{"never: wait for the slot", StartupNever, today.Add(-time.Hour), yesterday, today},
+		{"never: catch up the missed slot", StartupNever, today.Add(2 * time.Hour), yesterday, today.Add(2 * time.Hour)},
+		{"never: done today", StartupNever, today.Add(2 * time.Hour), today, tomorrow},
+		// the last success is the previous day in CST
+		{"if not done: another zone", StartupIfNotDone, today.Add(2 * time.Hour), time.Date(2022, 5, 4, 15, 0, 0, 0, time.UTC), today.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := NewStateStore("")
+			if !tt.last.IsZero() {
+				state.SetLastSuccess("test", tt.last)
+			}
+			cfg := Config{Time: Time{8, 0, cst}, Rand: fixedSource(5 * time.Minute), Startup: tt.policy, State: state}
+			s := startServe(cfg, tt.start)
+			want := []time.Time{tt.first, tt.first.AddDate(0, 0, 1)}
+			if tt.first.Hour() != 8 {
+				want[1] = tomorrow
+			}
+			if times := s.punches(t, len(want)); !reflect.DeepEqual(times, want) {
+				t.Errorf("expect punches at %v, got %v", want, times)
+			}
+			s.stop(t)
+			if last, _ := state.LastSuccess("test"); !last.Equal(want[1]) {
+				t.Errorf("expect the last success at %v, got %v", want[1], last)
+			}
+		})
+	}
+}
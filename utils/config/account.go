@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+var (
+	// ErrNoAccount the accounts file contains no account
+	ErrNoAccount = errors.New("accounts: no account provided")
+)
+
+// Account per-account configuration loaded from the accounts file.
+// Zero values of the optional fields fall back to the global Config.
+type Account struct {
+	Username    string               `json:"username"`
+	Password    string               `json:"password"`
+	PunchTime   *Schedule            `json:"punchTime,omitempty"`
+	MaxAttempts uint8                `json:"maxAttempts,omitempty"`
+	Notify      []string             `json:"notify,omitempty"`  // email receivers, override the receivers of the email config
+	Answers     map[string]string    `json:"answers,omitempty"` // answers of the report form, override the answers file
+	Force       bool                 `json:"force,omitempty"`   // submit even if today's report already exists
+	Retry       *Retry               `json:"retry,omitempty"`
+	Holidays    []serve.DateRange    `json:"holidays,omitempty"`    // override the holidays of the global config
+	HolidayFile string               `json:"holidayFile,omitempty"` // override the holiday file of the global config
+	Startup     *serve.StartupPolicy `json:"startup,omitempty"`
+}
+
+// Apply fill the unset fields of the account with the global config
+func (a Account) Apply(cfg Config) Account {
+	if a.PunchTime == nil {
+		s := cfg.PunchTime
+		a.PunchTime = &s
+	}
+	if a.MaxAttempts == 0 {
+		a.MaxAttempts = cfg.MaxAttempts
+	}
+	if a.Retry == nil {
+		r := cfg.Retry
+		a.Retry = &r
+	}
+	if a.Holidays == nil {
+		a.Holidays = cfg.Holidays
+	}
+	if a.HolidayFile == "" {
+		a.HolidayFile = cfg.HolidayFile
+	}
+	if a.Startup == nil {
+		p := cfg.Startup
+		a.Startup = &p
+	}
+	return a
+}
+
+// LoadHolidayFile append the holidays in the holiday file to Holidays,
+// the times in the file are converted into the location
+func (a *Account) LoadHolidayFile(loc *time.Location) error {
+	if a.HolidayFile == "" {
+		return nil
+	}
+	file, err := os.Open(a.HolidayFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	holidays, err := serve.ParseICS(file, loc)
+	if err != nil {
+		return fmt.Errorf("holidays: %s: %w", a.HolidayFile, err)
+	}
+	n := len(a.Holidays)
+	a.Holidays = append(a.Holidays[:n:n], holidays...) // don't modify the shared holidays
+	return nil
+}
+
+// LoadAccounts load the accounts from a json file, the file must
+// contain an array of accounts
+func LoadAccounts(path string) ([]Account, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var accounts []Account
+	if err = json.NewDecoder(file).Decode(&accounts); err != nil {
+		return nil, err
+	}
+	if err = CheckAccounts(accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// CheckAccounts check whether the accounts are valid
+func CheckAccounts(accounts []Account) error {
+	if len(accounts) == 0 {
+		return ErrNoAccount
+	}
+	names := make(map[string]struct{}, len(accounts))
+	for i, a := range accounts {
+		if a.Username == "" || a.Password == "" {
+			return fmt.Errorf("accounts: username or password of account #%d is empty", i+1)
+		}
+		if _, ok := names[a.Username]; ok {
+			return fmt.Errorf("accounts: duplicate account: %s", a.Username)
+		}
+		names[a.Username] = struct{}{}
+		if a.MaxAttempts > 120 {
+			return fmt.Errorf("accounts: max attempts of account %s: %w", a.Username, ErrOutOfRange)
+		}
+		if a.Retry != nil {
+			if err := a.Retry.check(); err != nil {
+				return fmt.Errorf("accounts: retry of account %s: %w", a.Username, err)
+			}
+		}
+		if err := checkHolidays(a.Holidays); err != nil {
+			return fmt.Errorf("accounts: account %s: %w", a.Username, err)
+		}
+	}
+	return nil
+}
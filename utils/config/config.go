@@ -1,11 +1,16 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
 )
 
 var (
@@ -15,16 +20,62 @@ var (
 	ErrWrongFormat = errors.New("time: wrong format")
 )
 
-// Time time config for scheduler
+// Time a time of day in `HH:MM` format
 type Time struct {
 	Hour   int
 	Minute int
 }
 
+// Duration a time.Duration encoded as a string like "5m" in json
+type Duration time.Duration
+
+// String return the duration in the format of time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implement encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return ErrOutOfRange
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// retry modes
+const (
+	RetryConstant    = "constant"
+	RetryExponential = "exponential"
+)
+
+// Retry retry policy config
+type Retry struct {
+	Mode       string   `json:"mode"`                 // RetryConstant or RetryExponential
+	Delay      Duration `json:"delay"`                // the constant delay, or the initial delay of exponential backoff
+	MaxDelay   Duration `json:"maxDelay,omitempty"`   // the cap of the delay of exponential backoff, 0 for no cap
+	Multiplier float64  `json:"multiplier,omitempty"` // the multiplier of exponential backoff, default: 2
+	Jitter     float64  `json:"jitter,omitempty"`     // the jitter fraction in [0, 1] of exponential backoff
+	GiveUpAt   *Time    `json:"giveUpAt,omitempty"`   // give up retrying at HH:MM, nil for no deadline
+}
+
 // Config config struct
 type Config struct {
-	MaxAttempts uint8 `json:"maxAttempts"`
-	PunchTime   Time  `json:"punchTime"`
+	MaxAttempts uint8               `json:"maxAttempts"`
+	PunchTime   Schedule            `json:"punchTime"`
+	Retry       Retry               `json:"retry"`
+	Holidays    []serve.DateRange   `json:"holidays,omitempty"`    // the dates to skip punching
+	HolidayFile string              `json:"holidayFile,omitempty"` // an iCalendar file of the dates to skip punching
+	Startup     serve.StartupPolicy `json:"startup"`
 }
 
 // Printer interface
@@ -34,12 +85,10 @@ type Printer interface {
 
 // SetFlag load config from args
 func (cfg *Config) SetFlag(flag *flag.FlagSet) {
-	if cfg.PunchTime == (Time{}) {
-		now := time.Now()
-		cfg.PunchTime.Hour = now.Hour()
-		cfg.PunchTime.Minute = now.Minute()
+	if cfg.PunchTime.Schedule == nil {
+		cfg.PunchTime.parse(time.Now().Format("15:04"))
 	}
-	flag.Func("t", "set punch time to `HH:MM`(default: now)", func(s string) error {
+	flag.Func("t", "set punch `schedule`, e.g. \"HH:MM\", \"HH:MM,HH:MM days=mon-fri jitter=5m\" or a cron expression(default: now)", func(s string) error {
 		if s != "" {
 			return cfg.PunchTime.parse(s)
 		}
@@ -54,28 +103,133 @@ func (cfg *Config) SetFlag(flag *flag.FlagSet) {
 		}
 		return nil
 	})
+	if cfg.Retry.Mode == "" {
+		cfg.Retry.Mode = RetryConstant
+	}
+	if cfg.Retry.Delay == 0 {
+		cfg.Retry.Delay = Duration(5 * time.Minute)
+	}
+	flag.Func("retry", "set retry `mode`: constant or exponential(default: constant)", func(s string) error {
+		return cfg.Retry.parseMode(s)
+	})
+	flag.Func("retry-delay", "set the constant retry `delay`, or the initial delay of exponential backoff(default: 5m)", func(s string) error {
+		return cfg.Retry.Delay.UnmarshalText([]byte(s))
+	})
+	flag.Func("retry-max-delay", "set the maximum `delay` of exponential backoff(default: no limit)", func(s string) error {
+		return cfg.Retry.MaxDelay.UnmarshalText([]byte(s))
+	})
+	flag.Func("retry-jitter", "set the jitter `fraction` in [0, 1] of exponential backoff(default: 0)", func(s string) error {
+		return parseFraction(&cfg.Retry.Jitter, s)
+	})
+	flag.Func("give-up-at", "give up retrying at `HH:MM`(default: no deadline)", func(s string) error {
+		if s == "" {
+			cfg.Retry.GiveUpAt = nil
+			return nil
+		}
+		t := &Time{}
+		if err := t.parse(s); err != nil {
+			return err
+		}
+		cfg.Retry.GiveUpAt = t
+		return nil
+	})
+	cfg.Startup = serve.StartupIfNotDone // SetFlag is called before loading the config file
+	flag.Func("startup", "punch on start: always, if-not-done(today) or never(but catch up the missed punch of today)(default: if-not-done)", func(s string) error {
+		return cfg.Startup.UnmarshalText([]byte(s))
+	})
+	flag.StringVar(&cfg.HolidayFile, "holidays", cfg.HolidayFile, "skip punching on the dates of the events in an iCalendar `file`")
+}
+
+// Load load config from a json file, the fields not provided are kept
+func (cfg *Config) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = json.NewDecoder(file).Decode(cfg); err != nil {
+		return err
+	}
+	return cfg.Check()
 }
 
 // Check check config
-//
-// Deprecated: use SetFlag to initialize config
 func (cfg Config) Check() error {
 	if cfg.MaxAttempts <= 0 || cfg.MaxAttempts > 120 {
 		return ErrOutOfRange
 	}
-	if cfg.PunchTime.Hour < 0 ||
-		cfg.PunchTime.Hour >= 24 ||
-		cfg.PunchTime.Minute < 0 ||
-		cfg.PunchTime.Minute >= 60 {
+	if cfg.PunchTime.Schedule == nil {
 		return ErrWrongFormat
 	}
-	return nil
+	if err := checkHolidays(cfg.Holidays); err != nil {
+		return err
+	}
+	return cfg.Retry.check()
 }
 
 // Show return configuration
 func (cfg Config) Show(logger Printer) {
 	logger.Printf("Maximum number of attempts: %d\n", cfg.MaxAttempts)
-	logger.Printf("Time set: %02d:%02d\n", cfg.PunchTime.Hour, cfg.PunchTime.Minute)
+	logger.Printf("Time set: %s\n", cfg.PunchTime)
+	logger.Printf("Retry: %s\n", cfg.Retry)
+	logger.Printf("Startup: %s\n", cfg.Startup)
+	if len(cfg.Holidays) != 0 || cfg.HolidayFile != "" {
+		logger.Printf("Holidays: %d date range(s), holiday file: %q\n", len(cfg.Holidays), cfg.HolidayFile)
+	}
+}
+
+// String return the description of the retry policy
+func (r Retry) String() string {
+	s := fmt.Sprintf("%s, delay: %s", r.Mode, r.Delay)
+	if r.Mode == RetryExponential {
+		s += fmt.Sprintf(", max delay: %s, multiplier: %g, jitter: %g", r.MaxDelay, r.Multiplier, r.Jitter)
+	}
+	if r.GiveUpAt != nil {
+		s += ", give up at: " + r.GiveUpAt.String()
+	}
+	return s
+}
+
+func (r *Retry) parseMode(s string) error {
+	switch s {
+	case RetryConstant, RetryExponential:
+		r.Mode = s
+		return nil
+	}
+	return fmt.Errorf("retry: unknown mode: %s", s)
+}
+
+func (r Retry) check() error {
+	if r.Mode != "" {
+		if err := r.parseMode(r.Mode); err != nil {
+			return err
+		}
+	}
+	if r.Jitter < 0 || r.Jitter > 1 || r.Multiplier < 0 || r.Delay < 0 || r.MaxDelay < 0 {
+		return ErrOutOfRange
+	}
+	return nil
+}
+
+func checkHolidays(holidays []serve.DateRange) error {
+	for _, r := range holidays {
+		if r.Start == (serve.Date{}) || r.End != (serve.Date{}) && r.End.Before(r.Start) {
+			return fmt.Errorf("holidays: invalid date range: %s - %s", r.Start, r.End)
+		}
+	}
+	return nil
+}
+
+func parseFraction(f *float64, text string) error {
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return err
+	}
+	if v < 0 || v > 1 {
+		return ErrOutOfRange
+	}
+	*f = v
+	return nil
 }
 
 func parseAttempts(t *uint8, text string) (err error) {
@@ -113,3 +267,18 @@ func (t *Time) parse(text string) error {
 	t.Minute = minute
 	return err
 }
+
+// String return the time in `HH:MM` format
+func (t Time) String() string {
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+// MarshalText implement encoding.TextMarshaler
+func (t Time) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler, the text must be in `HH:MM` format
+func (t *Time) UnmarshalText(text []byte) error {
+	return t.parse(string(text))
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+// Schedule the punch schedule, see serve.ParseSchedule for the syntax
+type Schedule struct {
+	*serve.Schedule
+}
+
+// String return the schedule in the syntax of serve.ParseSchedule
+func (s Schedule) String() string {
+	if s.Schedule == nil {
+		return ""
+	}
+	return s.Schedule.String()
+}
+
+// MarshalText implement encoding.TextMarshaler
+func (s Schedule) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implement encoding.TextUnmarshaler
+func (s *Schedule) UnmarshalText(text []byte) error {
+	return s.parse(string(text))
+}
+
+func (s *Schedule) parse(text string) (err error) {
+	var schedule *serve.Schedule
+	if schedule, err = serve.ParseSchedule(text); err == nil {
+		s.Schedule = schedule
+	}
+	return
+}
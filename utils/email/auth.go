@@ -0,0 +1,165 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// AuthMechanism the SMTP auth mechanism
+type AuthMechanism string
+
+const (
+	// AuthAuto choose the first mechanism advertised by the server in XOAUTH2(only
+	// if the token file is set), PLAIN, LOGIN and CRAM-MD5 over TLS, or in XOAUTH2,
+	// CRAM-MD5, PLAIN and LOGIN on an unencrypted connection(e.g. to localhost)
+	AuthAuto AuthMechanism = ""
+	// AuthNone do not authenticate, e.g. for a local relay
+	AuthNone AuthMechanism = "none"
+	// AuthPlain AUTH PLAIN
+	AuthPlain AuthMechanism = "plain"
+	// AuthLogin AUTH LOGIN
+	AuthLogin AuthMechanism = "login"
+	// AuthCRAMMD5 AUTH CRAM-MD5
+	AuthCRAMMD5 AuthMechanism = "cram-md5"
+	// AuthXOAUTH2 AUTH XOAUTH2 with the OAuth2 access token read from the token file
+	AuthXOAUTH2 AuthMechanism = "xoauth2"
+)
+
+// ErrUnknownAuth the auth mechanism is unknown
+var ErrUnknownAuth = errors.New("smtp: unknown auth mechanism")
+
+// errUnencrypted the credentials would be sent on an unencrypted connection
+var errUnencrypted = errors.New("unencrypted connection")
+
+var (
+	// tlsMechanisms the mechanisms tried by AuthAuto in order over TLS
+	tlsMechanisms = [...]AuthMechanism{AuthPlain, AuthLogin, AuthCRAMMD5}
+	// plainMechanisms the mechanisms tried by AuthAuto in order without TLS,
+	// CRAM-MD5 is preferred as it does not send the password
+	plainMechanisms = [...]AuthMechanism{AuthCRAMMD5, AuthPlain, AuthLogin}
+)
+
+// auth return the auth negotiated from the mechanisms advertised by the server,
+// nil for AuthNone
+func (cfg *SmtpConfig) auth(c *smtp.Client) (smtp.Auth, error) {
+	mech := AuthMechanism(strings.ToLower(string(cfg.Auth)))
+	switch mech {
+	case AuthNone:
+		return nil, nil
+	case AuthAuto, AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAuth, cfg.Auth)
+	}
+	ok, params := c.Extension("AUTH")
+	if !ok {
+		return nil, ErrNotSupportAuth
+	}
+	advertised := make(map[AuthMechanism]bool)
+	for _, m := range strings.Fields(params) {
+		advertised[AuthMechanism(strings.ToLower(m))] = true
+	}
+	if mech == AuthAuto {
+		mechanisms := plainMechanisms[:]
+		if isTLS(c) {
+			mechanisms = tlsMechanisms[:]
+		}
+		if cfg.TokenFile != "" { // XOAUTH2 is tried only with a token
+			mechanisms = append([]AuthMechanism{AuthXOAUTH2}, mechanisms...)
+		}
+		for _, m := range mechanisms {
+			if advertised[m] {
+				mech = m
+				break
+			}
+		}
+		if mech == AuthAuto {
+			return nil, fmt.Errorf("%w: no supported mechanism in %q", ErrNotSupportAuth, params)
+		}
+	} else if !advertised[mech] {
+		return nil, fmt.Errorf("%w: %s", ErrNotSupportAuth, strings.ToUpper(string(mech)))
+	}
+
+	switch mech {
+	case AuthLogin:
+		return &loginAuth{cfg.Username, cfg.Password, cfg.Host}, nil
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(cfg.Username, cfg.Password), nil
+	case AuthXOAUTH2:
+		token, err := os.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: read token file failed(Err: %w)", err)
+		}
+		return &xoauth2Auth{cfg.Username, string(bytes.TrimSpace(token)), cfg.Host}, nil
+	default:
+		return smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host), nil
+	}
+}
+
+// isTLS report whether the connection is encrypted by TLS or STARTTLS
+func isTLS(c *smtp.Client) bool {
+	_, ok := c.TLSConnectionState()
+	return ok
+}
+
+// checkTLS refuse to send the credentials on an unencrypted connection
+// except to localhost, the same as smtp.PlainAuth
+func checkTLS(server *smtp.ServerInfo, host string) error {
+	if server.TLS || server.Name == "localhost" || server.Name == "127.0.0.1" || server.Name == "::1" {
+		if server.Name != host {
+			return errors.New("wrong host name")
+		}
+		return nil
+	}
+	return errUnencrypted
+}
+
+// loginAuth AUTH LOGIN
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkTLS(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch prompt := strings.ToLower(string(fromServer)); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %q", fromServer)
+	}
+}
+
+// xoauth2Auth AUTH XOAUTH2
+type xoauth2Auth struct {
+	username, token, host string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkTLS(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// the server sends the error details in a challenge,
+		// an empty response is required to get the final reply
+		return []byte{}, nil
+	}
+	return nil, nil
+}
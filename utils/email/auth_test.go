@@ -0,0 +1,141 @@
+package email
+
+import (
+	"errors"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yin1999/healthreport/v2/utils/email/smtptest"
+)
+
+func TestAuth(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("access-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name       string
+		auth       AuthMechanism
+		password   string
+		tokenFile  string
+		mechanisms []string // advertised by the server, nil for the defaults
+		want       []string // the mechanisms used
+		err        error
+		code       int // the smtp reply code of the error
+	}{
+		{name: "auto", want: []string{"CRAM-MD5"}},
+		{name: "auto with token", tokenFile: tokenFile, want: []string{"XOAUTH2"}},
+		{name: "auto plain only", mechanisms: []string{"PLAIN"}, want: []string{"PLAIN"}},
+		{name: "plain", auth: AuthPlain, want: []string{"PLAIN"}},
+		{name: "login", auth: AuthLogin, want: []string{"LOGIN"}},
+		{name: "cram-md5", auth: "CRAM-MD5", want: []string{"CRAM-MD5"}},
+		{name: "xoauth2", auth: AuthXOAUTH2, tokenFile: tokenFile, want: []string{"XOAUTH2"}},
+		{name: "none", auth: AuthNone, mechanisms: []string{}},
+		{name: "not advertised", auth: AuthLogin, mechanisms: []string{"PLAIN"}, err: ErrNotSupportAuth},
+		{name: "no auth extension", mechanisms: []string{}, err: ErrNotSupportAuth},
+		{name: "auto no supported", mechanisms: []string{"NTLM"}, err: ErrNotSupportAuth},
+		{name: "unknown", auth: "ntlm", err: ErrUnknownAuth},
+		{name: "wrong password", auth: AuthLogin, password: "wrong", code: 535},
+		{name: "missing token file", auth: AuthXOAUTH2, tokenFile: filepath.Join(t.TempDir(), "missing"), err: os.ErrNotExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := smtptest.NewServer("user@example.com", "password")
+			defer s.Close()
+			s.SetToken("access-token")
+			if tt.mechanisms != nil {
+				s.SetMechanisms(tt.mechanisms...)
+			}
+			password := tt.password
+			if password == "" {
+				password = "password"
+			}
+			cfg := &Config{
+				To: []string{"a@example.com"},
+				SMTP: SmtpConfig{
+					Host:      s.Host(),
+					Port:      s.Port(),
+					Username:  "user@example.com",
+					Password:  password,
+					Auth:      tt.auth,
+					TokenFile: tt.tokenFile,
+				},
+			}
+			for _, f := range []func() error{
+				cfg.LoginTest,
+				func() error { return cfg.Send("测试", "测试", "这是一封测试邮件") },
+			} {
+				err := f()
+				var reply *textproto.Error
+				switch {
+				case tt.code != 0:
+					if !errors.As(err, &reply) || reply.Code != tt.code {
+						t.Fatalf("expect reply %d, got %v", tt.code, err)
+					}
+				case !errors.Is(err, tt.err):
+					t.Fatalf("expect %v, got %v", tt.err, err)
+				}
+			}
+			if tt.err != nil || tt.code != 0 {
+				if n := len(s.Messages()); n != 0 {
+					t.Errorf("expect no message, got %d", n)
+				}
+				return
+			}
+			// LoginTest and Send
+			want := append(append([]string(nil), tt.want...), tt.want...)
+			if auths := s.Auths(); !reflect.DeepEqual(auths, want) {
+				t.Errorf("expect auths %v, got %v", want, auths)
+			}
+			if n := len(s.Messages()); n != 1 {
+				t.Errorf("expect 1 message, got %d", n)
+			}
+		})
+	}
+}
+
+func TestXOAUTH2Rejected(t *testing.T) {
+	s := smtptest.NewServer("user@example.com", "password")
+	defer s.Close()
+	s.SetToken("new-token")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("expired-token"), 0600)
+	cfg := &Config{SMTP: SmtpConfig{Host: s.Host(), Port: s.Port(), Username: "user@example.com", Auth: AuthXOAUTH2, TokenFile: tokenFile}}
+	var reply *textproto.Error
+	if err := cfg.LoginTest(); !errors.As(err, &reply) || reply.Code != 535 {
+		t.Fatalf("expect reply 535, got %v", err)
+	}
+	// the token file is read on every login
+	os.WriteFile(tokenFile, []byte("new-token"), 0600)
+	if err := cfg.LoginTest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthAuto(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("access-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"plain": "CRAM-MD5", "starttls": "PLAIN", "tls": "PLAIN"}
+	for _, tt := range servers {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.start)
+			s.SetToken("access-token")
+			cfg := newConfig(s, tt.tls)
+			if err := cfg.LoginTest(); err != nil {
+				t.Fatal(err)
+			}
+			cfg.SMTP.TokenFile = tokenFile
+			if err := cfg.LoginTest(); err != nil {
+				t.Fatal(err)
+			}
+			// XOAUTH2 only with the token
+			if auths := s.Auths(); !reflect.DeepEqual(auths, []string{want[tt.name], "XOAUTH2"}) {
+				t.Errorf("expect auths %v, got %v", []string{want[tt.name], "XOAUTH2"}, auths)
+			}
+		})
+	}
+}
@@ -1,8 +1,8 @@
 package email
 
 import (
-	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"net"
@@ -10,8 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	_ "unsafe"
+	"time"
 )
 
 var (
@@ -21,6 +20,9 @@ var (
 	ErrNoReceiver = errors.New("mail: no receiver")
 )
 
+// rootCAs the CAs to verify the server, nil for the system CAs, replaced in tests
+var rootCAs *x509.CertPool
+
 // SmtpConfig smtp config
 type SmtpConfig struct {
 	Host     string `json:"host"`
@@ -28,6 +30,12 @@ type SmtpConfig struct {
 	TLS      bool   `json:"TLS"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Auth the auth mechanism: plain, login, cram-md5, xoauth2 or none,
+	// negotiated from the mechanisms advertised by the server if empty
+	Auth AuthMechanism `json:"auth,omitempty"`
+	// TokenFile the file containing the OAuth2 access token for xoauth2,
+	// it is read on every login so it can be refreshed by another program
+	TokenFile string `json:"tokenFile,omitempty"`
 }
 
 // Config smtp config
@@ -39,58 +47,52 @@ type Config struct {
 // LoginTest return nil, expect cannot login to the server
 func (cfg *Config) LoginTest() error {
 	config := cfg.SMTP
-	a := smtp.PlainAuth("",
-		config.Username,
-		config.Password,
-		config.Host)
-
 	c, err := newClient(config.Host, config.Port, config.TLS)
 	if err != nil {
 		return err
 	}
+	defer c.Close()
 
-	if ok, _ := c.Extension("AUTH"); !ok {
-		return ErrNotSupportAuth
-	}
-
-	if err = c.Auth(a); err != nil {
+	a, err := config.auth(c)
+	if err != nil {
 		return err
 	}
-	c.Quit()
-	return err
+	if a != nil {
+		if err = c.Auth(a); err != nil {
+			return err
+		}
+	}
+	return c.Quit()
 }
 
-// Send send mail on STARTTLS/TLS port
+// Send send a plain text mail on STARTTLS/TLS port
 func (cfg *Config) Send(nickName, subject, body string) error {
+	return cfg.SendMessage(nickName, Message{Subject: subject, Text: body})
+}
+
+// SendMessage send the message on STARTTLS/TLS port
+func (cfg *Config) SendMessage(nickName string, m Message) error {
 	if len(cfg.To) == 0 {
 		return ErrNoReceiver
 	}
-	header := [][2]string{
-		{"From", nickName + "<" + cfg.SMTP.Username + ">"},
-		{"To", strings.Join(cfg.To, ";")},
-		{"Subject", subject},
-		{"Content-Type", "text/html; charset=UTF-8"},
-	}
-	message := bytes.Buffer{}
-	for _, v := range header {
-		message.WriteString(v[0] + ": " + v[1] + "\r\n")
+	message, err := cfg.build(nickName, m, time.Now(), newMessageID(cfg.SMTP.Username), "")
+	if err != nil {
+		return err
 	}
-	message.WriteString("\r\n" + body)
-	auth := smtp.PlainAuth(
-		"",
-		cfg.SMTP.Username,
-		cfg.SMTP.Password,
-		cfg.SMTP.Host,
-	)
 	client, err := newClient(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.TLS)
 	if err != nil {
 		return err
 	}
+	auth, err := cfg.SMTP.auth(client)
+	if err != nil {
+		client.Close()
+		return err
+	}
 	return sendMail(client,
 		auth,
 		cfg.SMTP.Username,
 		cfg.To,
-		message.Bytes(),
+		message,
 	)
 }
 
@@ -128,7 +130,7 @@ func newClient(host string, port int, TLS bool) (client *smtp.Client, err error)
 	if TLS {
 		conn, err = tls.Dial("tcp",
 			addr,
-			&tls.Config{ServerName: host},
+			&tls.Config{ServerName: host, RootCAs: rootCAs},
 		)
 	} else {
 		conn, err = net.Dial("tcp", addr)
@@ -144,7 +146,7 @@ func newClient(host string, port int, TLS bool) (client *smtp.Client, err error)
 	if TLS {
 		err = client.Hello("localhost")
 	} else if ok, _ := client.Extension("STARTTLS"); ok {
-		err = client.StartTLS(&tls.Config{ServerName: host})
+		err = client.StartTLS(&tls.Config{ServerName: host, RootCAs: rootCAs})
 	}
 
 	if err != nil {
@@ -167,9 +169,6 @@ func sendMail(c *smtp.Client, a smtp.Auth, from string, to []string, msg []byte)
 	}
 
 	if a != nil {
-		if ok, _ := c.Extension("AUTH"); !ok {
-			return ErrNotSupportAuth
-		}
 		if err = c.Auth(a); err != nil {
 			return err
 		}
@@ -197,5 +196,10 @@ func sendMail(c *smtp.Client, a smtp.Auth, from string, to []string, msg []byte)
 	return c.Quit()
 }
 
-//go:linkname validateLine net/smtp.validateLine
-func validateLine(line string) error
+// validateLine check the line contains no CR or LF, the same as net/smtp
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\n\r") {
+		return errors.New("smtp: A line must not contain CR or LF")
+	}
+	return nil
+}
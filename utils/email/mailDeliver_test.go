@@ -1,44 +1,169 @@
 package email
 
 import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/yin1999/healthreport/v2/utils/email/smtptest"
 )
 
-var (
-	config *Config
+const (
+	testUsername = "bot@example.com"
+	testPassword = "password"
 )
 
-func TestWrapper(t *testing.T) {
-	testLoadConfig(t)
-	testLoginTest(t)
-	testSend(t)
+// servers the fake servers of every TLS mode, tls is the Config.SMTP.TLS to use
+var servers = []struct {
+	name  string
+	start func(username, password string) *smtptest.Server
+	tls   bool
+}{
+	{"plain", smtptest.NewServer, false},
+	{"starttls", smtptest.NewStartTLSServer, false},
+	{"tls", smtptest.NewTLSServer, true},
+}
+
+// newTestServer start a fake server trusted by the client
+func newTestServer(t *testing.T, start func(username, password string) *smtptest.Server) *smtptest.Server {
+	t.Helper()
+	s := start(testUsername, testPassword)
+	old := rootCAs
+	rootCAs = s.CertPool()
+	t.Cleanup(func() {
+		rootCAs = old
+		s.Close()
+	})
+	return s
 }
 
-func testLoadConfig(t *testing.T) {
-	var err error
-	config, err = LoadConfig("not/exist.json")
-	if config != nil || err == nil {
-		t.Fail()
+// newConfig return the config sending mails to the addresses through the server
+func newConfig(s *smtptest.Server, tls bool, to ...string) *Config {
+	return &Config{
+		To: to,
+		SMTP: SmtpConfig{
+			Host:     s.Host(),
+			Port:     s.Port(),
+			TLS:      tls,
+			Username: testUsername,
+			Password: testPassword,
+		},
 	}
-	config, err = LoadConfig("../../email.json")
-	if config == nil || err != nil {
-		t.Fatal(err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	if cfg, err := LoadConfig("not/exist.json"); cfg != nil || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expect ErrNotExist, got %v, %v", cfg, err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "email.json")
+	data, _ := json.Marshal(Example())
+	os.WriteFile(path, data, 0600)
+	if cfg, err := LoadConfig(path); err != nil || !reflect.DeepEqual(cfg, Example()) {
+		t.Errorf("expect the example config, got %+v(err: %v)", cfg, err)
+	}
+
+	os.WriteFile(path, []byte("{"), 0600)
+	if cfg, err := LoadConfig(path); cfg != nil || err == nil {
+		t.Errorf("expect an error, got %+v", cfg)
 	}
 }
 
-func testLoginTest(t *testing.T) {
-	err := config.LoginTest()
-	if err != nil {
-		t.Fatal(err)
+func TestLoginTest(t *testing.T) {
+	for _, tt := range servers {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.start)
+			cfg := newConfig(s, tt.tls)
+			if err := cfg.LoginTest(); err != nil {
+				t.Fatal(err)
+			}
+			if auths := s.Auths(); len(auths) != 1 {
+				t.Errorf("expect 1 auth, got %v", auths)
+			}
+
+			cfg.SMTP.Password = "wrong"
+			var reply *textproto.Error
+			if err := cfg.LoginTest(); !errors.As(err, &reply) || reply.Code != 535 {
+				t.Errorf("expect reply 535, got %v", err)
+			}
+		})
+	}
+
+	// the certificate is not trusted
+	s := smtptest.NewTLSServer(testUsername, testPassword)
+	defer s.Close()
+	if err := newConfig(s, true).LoginTest(); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expect a certificate error, got %v", err)
 	}
 }
 
-func testSend(t *testing.T) {
-	err := config.Send("测试邮件",
-		"测试",
-		"这是一封测试邮件",
-	)
-	if err != nil {
-		t.Fatal(err)
+func TestSend(t *testing.T) {
+	to := []string{"a@example.com", "b@example.com", "c@example.com"}
+	for _, tt := range servers {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.start)
+			if err := newConfig(s, tt.tls, to...).Send("测试邮件", "测试", "这是一封测试邮件"); err != nil {
+				t.Fatal(err)
+			}
+			messages := s.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("expect 1 message, got %d", len(messages))
+			}
+			m := messages[0]
+			if m.From != testUsername || !reflect.DeepEqual(m.To, to) {
+				t.Errorf("unexpected envelope: %s -> %v", m.From, m.To)
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(string(m.Data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "测试" {
+				t.Errorf("expect subject 测试, got %q", subject)
+			}
+			if addrs, err := msg.Header.AddressList("To"); err != nil || len(addrs) != len(to) {
+				t.Errorf("unexpected To: %v(err: %v)", addrs, err)
+			}
+		})
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	s := newTestServer(t, smtptest.NewServer)
+
+	if err := newConfig(s, false).Send("测试邮件", "测试", "body"); !errors.Is(err, ErrNoReceiver) {
+		t.Errorf("expect ErrNoReceiver, got %v", err)
+	}
+
+	cfg := newConfig(s, false, "a@example.com", "rejected@example.com")
+	s.RejectRecipient("rejected@example.com")
+	var reply *textproto.Error
+	if err := cfg.Send("测试邮件", "测试", "body"); !errors.As(err, &reply) || reply.Code != 550 {
+		t.Errorf("expect reply 550, got %v", err)
+	}
+
+	cfg.To = []string{"a@example.com\r\nRCPT TO:<evil@example.com>"}
+	if err := cfg.Send("测试邮件", "测试", "body"); err == nil || !strings.Contains(err.Error(), "CR or LF") {
+		t.Errorf("expect the line error, got %v", err)
+	}
+
+	s.SetMechanisms()
+	cfg.To = []string{"a@example.com"}
+	if err := cfg.Send("测试邮件", "测试", "body"); !errors.Is(err, ErrNotSupportAuth) {
+		t.Errorf("expect ErrNotSupportAuth, got %v", err)
+	}
+	if err := cfg.LoginTest(); !errors.Is(err, ErrNotSupportAuth) {
+		t.Errorf("expect ErrNotSupportAuth, got %v", err)
+	}
+
+	if n := len(s.Messages()); n != 0 {
+		t.Errorf("expect no message, got %d", n)
 	}
 }
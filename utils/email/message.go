@@ -0,0 +1,96 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message an email with a plain text body and an optional html alternative
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string // empty for a plain text email
+}
+
+// build build the MIME message, the boundary is random if empty
+func (cfg *Config) build(nickName string, m Message, date time.Time, messageID, boundary string) ([]byte, error) {
+	to := make([]string, len(cfg.To))
+	for i, addr := range cfg.To {
+		to[i] = (&mail.Address{Address: addr}).String()
+	}
+	buf := &bytes.Buffer{}
+	for _, v := range [...][2]string{
+		{"From", (&mail.Address{Name: nickName, Address: cfg.SMTP.Username}).String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.BEncoding.Encode("UTF-8", m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+	} {
+		buf.WriteString(v[0] + ": " + v[1] + "\r\n")
+	}
+
+	if m.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(buf)
+	if boundary != "" {
+		if err := w.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + w.Boundary() + "\r\n\r\n")
+	for _, part := range [...][2]string{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part[0]},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeQuotedPrintable(pw, part[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qw, s); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// newMessageID return a random message id in the domain of the address
+func newMessageID(address string) string {
+	domain := "healthreport"
+	if i := strings.LastIndexByte(address, '@'); i >= 0 && i+1 < len(address) {
+		domain = address[i+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
@@ -0,0 +1,108 @@
+package email
+
+import (
+	"flag"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var testMessage = Message{
+	Subject: "打卡状态推送-2022-05-05",
+	Text:    "账户: test 打卡失败\n\n尝试次数: 3\n错误类型: auth\n服务器消息: 密码错误=请重新登录, 这是一行很长的消息用于测试 quoted-printable 的软换行\n",
+	HTML:    "<p>账户: test 打卡失败</p>\n<p>服务器消息: 密码错误</p>\n",
+}
+
+func TestBuild(t *testing.T) {
+	cfg := &Config{To: []string{"a@example.com", "b@example.com"}}
+	cfg.SMTP.Username = "bot@example.com"
+	date := time.Date(2022, 5, 5, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	for name, m := range map[string]Message{
+		"multipart": testMessage,
+		"plain":     {Subject: testMessage.Subject, Text: testMessage.Text},
+	} {
+		data, err := cfg.build("打卡状态推送", m, date, "<test@example.com>", "healthreport-boundary")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		path := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err = os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(want) != string(data) {
+			t.Errorf("%s: mismatch, want:\n%s\ngot:\n%s", name, want, data)
+		}
+
+		// the message must be decoded to the original one
+		msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != m.Subject {
+			t.Errorf("%s: expect subject %q, got %q(err: %v)", name, m.Subject, subject, err)
+		}
+		if from, err := msg.Header.AddressList("From"); err != nil || from[0].Name != "打卡状态推送" {
+			t.Errorf("%s: unexpected from: %v(err: %v)", name, from, err)
+		}
+		if to, err := msg.Header.AddressList("To"); err != nil || len(to) != 2 {
+			t.Errorf("%s: unexpected to: %v(err: %v)", name, to, err)
+		}
+		mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		var parts []string
+		if mediaType == "multipart/alternative" {
+			r := multipart.NewReader(msg.Body, params["boundary"])
+			for {
+				p, err := r.NextPart()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				// multipart.Reader decodes the quoted-printable parts
+				body, _ := io.ReadAll(p)
+				parts = append(parts, string(body))
+			}
+		} else {
+			body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+			parts = append(parts, string(body))
+		}
+		bodies := []string{m.Text, m.HTML}
+		if m.HTML == "" {
+			bodies = bodies[:1]
+		}
+		if len(parts) != len(bodies) {
+			t.Fatalf("%s: expect %d parts, got %d", name, len(bodies), len(parts))
+		}
+		for i := range bodies {
+			// quoted-printable writes CRLF line endings
+			if got := strings.ReplaceAll(parts[i], "\r\n", "\n"); got != bodies[i] {
+				t.Errorf("%s: part %d: expect %q, got %q", name, i, bodies[i], got)
+			}
+		}
+	}
+}
+
+func TestNewMessageID(t *testing.T) {
+	id := newMessageID("bot@example.com")
+	if !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") || id == newMessageID("bot@example.com") {
+		t.Errorf("unexpected message id: %s", id)
+	}
+	if id = newMessageID("bot"); !strings.HasSuffix(id, "@healthreport>") {
+		t.Errorf("unexpected message id: %s", id)
+	}
+}
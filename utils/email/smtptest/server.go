@@ -0,0 +1,459 @@
+// Package smtptest provides an in-process fake SMTP server for testing.
+package smtptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMechanisms the AUTH mechanisms advertised by default
+var DefaultMechanisms = []string{"PLAIN", "LOGIN", "CRAM-MD5", "XOAUTH2"}
+
+// Message a message received by the server
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// mode how the server uses TLS
+type mode int
+
+const (
+	modePlain    mode = iota // no TLS
+	modeStartTLS             // STARTTLS is required before AUTH
+	modeTLS                  // implicit TLS
+)
+
+// Server a fake SMTP server, it is safe for concurrent use
+type Server struct {
+	// Addr the address the server listens on, e.g. 127.0.0.1:25
+	Addr string
+
+	ln       net.Listener
+	wg       sync.WaitGroup
+	mode     mode
+	cert     *x509.Certificate
+	tls      *tls.Config
+	username string
+	password string
+
+	mux        sync.Mutex
+	token      string
+	mechanisms []string
+	auths      []string
+	messages   []Message
+	rejected   map[string]bool
+	closed     bool
+	conns      map[net.Conn]struct{}
+}
+
+// NewServer start a fake server without TLS on a random local port that accepts the
+// username and password, the server advertises DefaultMechanisms and requires
+// authentication before MAIL
+func NewServer(username, password string) *Server {
+	return newServer(username, password, modePlain)
+}
+
+// NewStartTLSServer start a fake server like NewServer, but AUTH is advertised only
+// after STARTTLS, the certificate of the server can be obtained by Server.Certificate
+func NewStartTLSServer(username, password string) *Server {
+	return newServer(username, password, modeStartTLS)
+}
+
+// NewTLSServer start a fake server like NewServer on implicit TLS,
+// the certificate of the server can be obtained by Server.Certificate
+func NewTLSServer(username, password string) *Server {
+	return newServer(username, password, modeTLS)
+}
+
+func newServer(username, password string, m mode) *Server {
+	s := &Server{
+		mode:       m,
+		username:   username,
+		password:   password,
+		mechanisms: DefaultMechanisms,
+		rejected:   make(map[string]bool),
+		conns:      make(map[net.Conn]struct{}),
+	}
+	var err error
+	if m != modePlain {
+		if s.cert, s.tls, err = generateCert(); err != nil {
+			panic("smtptest: failed to generate the certificate: " + err.Error())
+		}
+	}
+	s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("smtptest: failed to listen on a port: " + err.Error())
+	}
+	if m == modeTLS {
+		s.ln = tls.NewListener(s.ln, s.tls)
+	}
+	s.Addr = s.ln.Addr().String()
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// generateCert generate a self-signed certificate for localhost and 127.0.0.1
+func generateCert() (*x509.Certificate, *tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"smtptest"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+	}, nil
+}
+
+// Certificate return the certificate of the server, nil if the server does not use TLS
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// CertPool return a pool trusting the certificate of the server
+func (s *Server) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	if s.cert != nil {
+		pool.AddCert(s.cert)
+	}
+	return pool
+}
+
+// Host return the host of the server
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr)
+	return host
+}
+
+// Port return the port of the server
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// SetMechanisms set the advertised AUTH mechanisms, the server accepts
+// mail without authentication if there is no mechanism
+func (s *Server) SetMechanisms(mechanisms ...string) {
+	s.mux.Lock()
+	s.mechanisms = mechanisms
+	s.mux.Unlock()
+}
+
+// SetToken set the access token accepted by XOAUTH2
+func (s *Server) SetToken(token string) {
+	s.mux.Lock()
+	s.token = token
+	s.mux.Unlock()
+}
+
+// RejectRecipient reject the addresses in RCPT
+func (s *Server) RejectRecipient(addrs ...string) {
+	s.mux.Lock()
+	for _, addr := range addrs {
+		s.rejected[strings.ToLower(addr)] = true
+	}
+	s.mux.Unlock()
+}
+
+// Auths return the mechanisms of the successful authentications
+func (s *Server) Auths() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]string(nil), s.auths...)
+}
+
+// Messages return the received messages
+func (s *Server) Messages() []Message {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stop the server and close the connections
+func (s *Server) Close() {
+	s.mux.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mux.Unlock()
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mux.Lock()
+		if s.closed {
+			s.mux.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mux.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+			s.mux.Lock()
+			delete(s.conns, c)
+			s.mux.Unlock()
+		}()
+	}
+}
+
+// session the state of a connection
+type session struct {
+	*textproto.Conn
+	tls    bool
+	authed bool
+	from   string
+	to     []string
+}
+
+func (s *Server) handle(c net.Conn) {
+	conn := &session{Conn: textproto.NewConn(c), tls: s.mode == modeTLS}
+	defer func() { conn.Close() }()
+	conn.PrintfLine("220 smtptest ESMTP ready")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			lines := []string{"smtptest", "8BITMIME"}
+			if s.mode == modeStartTLS && !conn.tls {
+				lines = append(lines, "STARTTLS")
+			} else if mechanisms := s.getMechanisms(); len(mechanisms) != 0 {
+				lines = append(lines, "AUTH "+strings.Join(mechanisms, " "))
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				conn.PrintfLine("250%s%s", sep, l)
+			}
+		case "HELO":
+			conn.PrintfLine("250 smtptest")
+		case "STARTTLS":
+			if s.mode != modeStartTLS || conn.tls {
+				conn.PrintfLine("502 5.5.1 STARTTLS not available")
+				continue
+			}
+			conn.PrintfLine("220 2.0.0 Ready to start TLS")
+			tc := tls.Server(c, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			// the client must start over after STARTTLS
+			conn = &session{Conn: textproto.NewConn(tc), tls: true}
+		case "AUTH":
+			if s.mode == modeStartTLS && !conn.tls {
+				conn.PrintfLine("530 5.7.0 Must issue a STARTTLS command first")
+				continue
+			}
+			s.auth(conn, arg)
+		case "MAIL":
+			if !conn.authed && len(s.getMechanisms()) != 0 {
+				conn.PrintfLine("530 5.7.0 Authentication required")
+				continue
+			}
+			conn.from = address(arg, "FROM:")
+			conn.to = nil
+			conn.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			if conn.from == "" {
+				conn.PrintfLine("503 5.5.1 MAIL first")
+				continue
+			}
+			to := address(arg, "TO:")
+			s.mux.Lock()
+			rejected := s.rejected[strings.ToLower(to)]
+			s.mux.Unlock()
+			if rejected {
+				conn.PrintfLine("550 5.1.1 <%s>: Recipient address rejected", to)
+				continue
+			}
+			conn.to = append(conn.to, to)
+			conn.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			if len(conn.to) == 0 {
+				conn.PrintfLine("503 5.5.1 RCPT first")
+				continue
+			}
+			conn.PrintfLine("354 Start mail input; end with <CRLF>.<CRLF>")
+			data, err := io.ReadAll(conn.DotReader())
+			if err != nil {
+				return
+			}
+			s.mux.Lock()
+			s.messages = append(s.messages, Message{From: conn.from, To: conn.to, Data: data})
+			s.mux.Unlock()
+			conn.from, conn.to = "", nil
+			conn.PrintfLine("250 2.0.0 OK: queued")
+		case "RSET":
+			conn.from, conn.to = "", nil
+			conn.PrintfLine("250 2.0.0 OK")
+		case "NOOP":
+			conn.PrintfLine("250 2.0.0 OK")
+		case "QUIT":
+			conn.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			conn.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (s *Server) getMechanisms() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.mechanisms
+}
+
+// address return the address in the argument of MAIL or RCPT
+func address(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		arg = arg[:i] // drop the parameters, e.g. BODY=8BITMIME
+	}
+	return strings.Trim(arg, "<>")
+}
+
+// auth handle the AUTH command
+func (s *Server) auth(conn *session, arg string) {
+	if conn.authed {
+		conn.PrintfLine("503 5.5.1 Already authenticated")
+		return
+	}
+	mech, initial := arg, ""
+	hasInitial := false
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		mech, initial, hasInitial = arg[:i], arg[i+1:], true
+	}
+	mech = strings.ToUpper(mech)
+	advertised := false
+	for _, m := range s.getMechanisms() {
+		advertised = advertised || m == mech
+	}
+	if !advertised {
+		conn.PrintfLine("504 5.5.4 Unrecognized authentication type")
+		return
+	}
+
+	// challenge send the challenge and return the decoded response
+	challenge := func(c string) (string, bool) {
+		conn.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(c)))
+		line, err := conn.ReadLine()
+		if err != nil || line == "*" {
+			return "", false
+		}
+		resp, err := base64.StdEncoding.DecodeString(line)
+		return string(resp), err == nil
+	}
+	initialResponse := func() (string, bool) {
+		if !hasInitial {
+			return challenge("")
+		}
+		if initial == "=" {
+			return "", true
+		}
+		resp, err := base64.StdEncoding.DecodeString(initial)
+		return string(resp), err == nil
+	}
+
+	ok := false
+	switch mech {
+	case "PLAIN":
+		if resp, valid := initialResponse(); valid {
+			// authzid \0 authcid \0 passwd
+			parts := strings.Split(resp, "\x00")
+			ok = len(parts) == 3 && parts[1] == s.username && parts[2] == s.password
+		}
+	case "LOGIN":
+		if username, valid := challenge("Username:"); valid {
+			if password, valid := challenge("Password:"); valid {
+				ok = username == s.username && password == s.password
+			}
+		}
+	case "CRAM-MD5":
+		c := fmt.Sprintf("<%d.smtptest@%s>", len(s.Auths())+1, s.Host())
+		if resp, valid := challenge(c); valid {
+			h := hmac.New(md5.New, []byte(s.password))
+			h.Write([]byte(c))
+			ok = resp == s.username+" "+hex.EncodeToString(h.Sum(nil))
+		}
+	case "XOAUTH2":
+		if resp, valid := initialResponse(); valid {
+			s.mux.Lock()
+			token := s.token
+			s.mux.Unlock()
+			ok = token != "" && resp == "user="+s.username+"\x01auth=Bearer "+token+"\x01\x01"
+			if !ok {
+				challenge(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`)
+			}
+		}
+	}
+	if !ok {
+		conn.PrintfLine("535 5.7.8 Authentication credentials invalid")
+		return
+	}
+	conn.authed = true
+	s.mux.Lock()
+	s.auths = append(s.auths, mech)
+	s.mux.Unlock()
+	conn.PrintfLine("235 2.7.0 Authentication successful")
+}
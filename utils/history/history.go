@@ -0,0 +1,134 @@
+// Package history keep the punch history in a JSON lines file
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+// Store a punch history store, each record is a line of json in the file
+type Store struct {
+	path string
+	mux  sync.Mutex
+}
+
+// New return a history store of the file, the file is created
+// with 0600 permissions when the first record is written
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Record implement serve.Recorder
+func (s *Store) Record(r serve.Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		// start a new line if the last one is broken
+		last := make([]byte, 1)
+		if _, err = f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Filter the conditions of the records to query
+type Filter struct {
+	Account string    // empty for all the accounts
+	From    time.Time // the records started before From are excluded, zero for no limit
+	To      time.Time // the records started at or after To are excluded, zero for no limit
+}
+
+// Match report whether the record matches the filter
+func (f Filter) Match(r serve.Record) bool {
+	return (f.Account == "" || r.Account == f.Account) &&
+		(f.From.IsZero() || !r.Start.Before(f.From)) &&
+		(f.To.IsZero() || r.Start.Before(f.To))
+}
+
+// Query return the records matching the filter in the order of writing.
+// The broken lines(e.g. the process is killed while writing) are skipped,
+// and the lines are not limited in length, e.g. a long error message.
+func (s *Store) Query(f Filter) ([]serve.Record, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []serve.Record
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var r serve.Record
+		if len(line) != 0 && json.Unmarshal(line, &r) == nil && f.Match(r) {
+			records = append(records, r)
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+	}
+}
+
+// csvHeader the header of the csv export
+var csvHeader = []string{"account", "start", "end", "attempt", "status", "kind", "message", "error"}
+
+// WriteCSV write the records in csv format with a header
+func WriteCSV(w io.Writer, records []serve.Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		err := cw.Write([]string{
+			r.Account,
+			r.Start.Format(time.RFC3339),
+			r.End.Format(time.RFC3339),
+			strconv.Itoa(r.Attempt),
+			r.Status.String(),
+			r.Kind,
+			r.Message,
+			r.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON write the records as a json array
+func WriteJSON(w io.Writer, records []serve.Record) error {
+	if records == nil {
+		records = []serve.Record{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
@@ -0,0 +1,146 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+var cst = time.FixedZone("CST", 8*3600)
+
+var testRecords = []serve.Record{
+	{Account: "a", Start: time.Date(2022, 5, 1, 8, 0, 0, 0, cst), End: time.Date(2022, 5, 1, 8, 0, 3, 0, cst),
+		Attempt: 1, Status: serve.StatusFailed, Kind: "validation", Message: "信息填报不完整\r\n保存失败!", Error: "post: failed"},
+	{Account: "a", Start: time.Date(2022, 5, 1, 8, 5, 0, 0, cst), End: time.Date(2022, 5, 1, 8, 5, 2, 0, cst),
+		Attempt: 2, Status: serve.StatusSucceeded},
+	{Account: "b", Start: time.Date(2022, 5, 2, 8, 0, 0, 0, cst), End: time.Date(2022, 5, 2, 8, 0, 1, 0, cst),
+		Attempt: 1, Status: serve.StatusAlreadyDone},
+	{Account: "a", Start: time.Date(2022, 5, 3, 8, 0, 0, 0, cst), End: time.Date(2022, 5, 3, 8, 0, 0, 0, cst),
+		Status: serve.StatusSkipped, Message: "vacation"},
+}
+
+func newTestStore(t *testing.T) *Store {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s := New(path)
+	for _, r := range testRecords {
+		if err := s.Record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expect permissions 0600, got %o", perm)
+	}
+	return s
+}
+
+func TestQuery(t *testing.T) {
+	s := newTestStore(t)
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int // indexes of testRecords
+	}{
+		{"all", Filter{}, []int{0, 1, 2, 3}},
+		{"account", Filter{Account: "a"}, []int{0, 1, 3}},
+		{"from", Filter{From: time.Date(2022, 5, 2, 0, 0, 0, 0, cst)}, []int{2, 3}},
+		{"to", Filter{To: time.Date(2022, 5, 2, 0, 0, 0, 0, cst)}, []int{0, 1}},
+		{"range and account", Filter{Account: "a", From: time.Date(2022, 5, 1, 8, 1, 0, 0, cst), To: time.Date(2022, 5, 3, 0, 0, 0, 0, cst)}, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("expect %d records, got %d", len(tt.want), len(records))
+			}
+			for i, index := range tt.want {
+				want := testRecords[index]
+				if got := records[i]; got.Account != want.Account || !got.Start.Equal(want.Start) ||
+					got.Status != want.Status || got.Message != want.Message {
+					t.Errorf("expect %+v, got %+v", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryBrokenLine(t *testing.T) {
+	s := newTestStore(t)
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"account":"a","sta`) // killed while writing
+	f.Close()
+	if records, err := s.Query(Filter{}); err != nil || len(records) != len(testRecords) {
+		t.Errorf("expect the broken line skipped, got %d records, err: %v", len(records), err)
+	}
+
+	// the next record starts a new line
+	if err = s.Record(testRecords[0]); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := s.Query(Filter{}); err != nil || len(records) != len(testRecords)+1 {
+		t.Errorf("expect %d records, got %d, err: %v", len(testRecords)+1, len(records), err)
+	}
+
+	// a line longer than the default buffer of bufio.Scanner
+	long := testRecords[0]
+	long.Error = strings.Repeat("x", 100<<10)
+	if err = s.Record(long); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := s.Query(Filter{}); err != nil || len(records) != len(testRecords)+2 || records[len(records)-1].Error != long.Error {
+		t.Errorf("expect the long record, got %d records, err: %v", len(records), err)
+	}
+
+	if _, err = New(filepath.Join(t.TempDir(), "none")).Query(Filter{}); !os.IsNotExist(err) {
+		t.Errorf("expect not exist error, got %v", err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteCSV(buf, testRecords[:2]); err != nil {
+		t.Fatal(err)
+	}
+	want := "account,start,end,attempt,status,kind,message,error\n" +
+		"a,2022-05-01T08:00:00+08:00,2022-05-01T08:00:03+08:00,1,failed,validation,\"信息填报不完整\r\n保存失败!\",post: failed\n" +
+		"a,2022-05-01T08:05:00+08:00,2022-05-01T08:05:02+08:00,2,succeeded,,,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expect:\n%q\ngot:\n%q", want, got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteJSON(buf, nil); err != nil || strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("expect an empty array, got %q, err: %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	if err := WriteJSON(buf, testRecords); err != nil {
+		t.Fatal(err)
+	}
+	var records []serve.Record
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(testRecords) || records[3].Status != serve.StatusSkipped ||
+		!reflect.DeepEqual(records[0].Kind, testRecords[0].Kind) {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
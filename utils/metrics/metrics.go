@@ -0,0 +1,337 @@
+// Package metrics provides counters, gauges and histograms exposed in
+// the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets the default buckets of histograms in seconds
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Default the default registry
+var Default = NewRegistry()
+
+// Registry a set of metrics
+type Registry struct {
+	mux     sync.Mutex
+	metrics []*vec
+}
+
+// NewRegistry return an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// vec a metric with its series of the label values
+type vec struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64 // upper bounds of histograms, in increasing order
+
+	mux    sync.Mutex
+	series map[string]*series // by the joined label values
+}
+
+type series struct {
+	value  atomicFloat // the value of counters and gauges
+	values []string
+
+	mux    sync.Mutex // protect the fields of histograms below
+	counts []uint64   // the count of each bucket(not cumulative) and +Inf
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) register(v *vec) *vec {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, m := range r.metrics {
+		if m.name == v.name {
+			panic("metrics: duplicate metric: " + v.name)
+		}
+	}
+	v.series = make(map[string]*series)
+	if len(v.labels) == 0 { // expose the zero value
+		v.with()
+	}
+	r.metrics = append(r.metrics, v)
+	return v
+}
+
+// with return the series of the label values, created if not exists
+func (v *vec) with(values ...string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s: expect %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if v.typ == typeHistogram {
+			s.counts = make([]uint64, len(v.buckets)+1)
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// CounterVec a counter partitioned by the labels
+type CounterVec struct {
+	v *vec
+}
+
+// NewCounterVec register a counter
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&vec{name: name, help: help, typ: typeCounter, labels: labels})}
+}
+
+// With return the counter of the label values
+func (c *CounterVec) With(values ...string) Counter {
+	return Counter{c.v.with(values...)}
+}
+
+// Counter a value which only goes up
+type Counter struct {
+	s *series
+}
+
+// Inc add 1 to the counter
+func (c Counter) Inc() {
+	c.s.value.add(1)
+}
+
+// Add add a non-negative value to the counter
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.s.value.add(v)
+}
+
+// Value return the current value of the counter
+func (c Counter) Value() float64 {
+	return c.s.value.load()
+}
+
+// GaugeVec a gauge partitioned by the labels
+type GaugeVec struct {
+	v *vec
+}
+
+// NewGaugeVec register a gauge
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(&vec{name: name, help: help, typ: typeGauge, labels: labels})}
+}
+
+// With return the gauge of the label values
+func (g *GaugeVec) With(values ...string) Gauge {
+	return Gauge{g.v.with(values...)}
+}
+
+// Delete remove the gauge of the label values
+func (g *GaugeVec) Delete(values ...string) {
+	g.v.mux.Lock()
+	defer g.v.mux.Unlock()
+	delete(g.v.series, strings.Join(values, "\xff"))
+}
+
+// Gauge a value which goes up and down
+type Gauge struct {
+	s *series
+}
+
+// Set set the gauge
+func (g Gauge) Set(v float64) {
+	g.s.value.set(v)
+}
+
+// Add add the value(may be negative) to the gauge
+func (g Gauge) Add(v float64) {
+	g.s.value.add(v)
+}
+
+// Value return the current value of the gauge
+func (g Gauge) Value() float64 {
+	return g.s.value.load()
+}
+
+// HistogramVec a histogram partitioned by the labels
+type HistogramVec struct {
+	v *vec
+}
+
+// NewHistogramVec register a histogram, the buckets are the upper bounds
+// in increasing order, and the +Inf bucket is added implicitly
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: " + name + ": buckets are not sorted")
+	}
+	return &HistogramVec{r.register(&vec{name: name, help: help, typ: typeHistogram, labels: labels, buckets: buckets})}
+}
+
+// With return the histogram of the label values
+func (h *HistogramVec) With(values ...string) Histogram {
+	return Histogram{h.v.with(values...), h.v.buckets}
+}
+
+// Histogram count the observations in buckets
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Observe add an observation
+func (h Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v) // the first bucket whose bound >= v
+	h.s.mux.Lock()
+	h.s.counts[i]++
+	h.s.sum += v
+	h.s.count++
+	h.s.mux.Unlock()
+}
+
+// atomicFloat a float64 updated atomically
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Write write the metrics in the Prometheus text format(version 0.0.4)
+func (r *Registry) Write(w io.Writer) error {
+	r.mux.Lock()
+	metrics := append([]*vec(nil), r.metrics...)
+	r.mux.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, v := range metrics {
+		v.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler return a http handler exposing the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mux.Lock()
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	v.mux.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].values, all[j].values
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, helpEscaper.Replace(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+	for _, s := range all {
+		if v.typ != typeHistogram {
+			writeSample(w, v.name, v.labels, s.values, "", "", s.value.load())
+			continue
+		}
+		s.mux.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mux.Unlock()
+		var cumulative uint64
+		for i, bound := range v.buckets {
+			cumulative += counts[i]
+			writeSample(w, v.name+"_bucket", v.labels, s.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		cumulative += counts[len(v.buckets)]
+		writeSample(w, v.name+"_bucket", v.labels, s.values, "le", "+Inf", float64(cumulative))
+		writeSample(w, v.name+"_sum", v.labels, s.values, "", "", sum)
+		writeSample(w, v.name+"_count", v.labels, s.values, "", "", float64(count))
+	}
+}
+
+// writeSample write a sample line, the extra label is appended if its name is not empty
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) != 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i != 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, labelEscaper.Replace(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) != 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	attempts := r.NewCounterVec("test_attempts_total", "Attempts.", "account")
+	r.NewCounterVec("test_idle_total", "Never increased.")
+	last := r.NewGaugeVec("test_last_seconds", "Last time,\nin seconds.", "account")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.5, 1}, "result")
+
+	attempts.With("b").Inc()
+	attempts.With("a").Add(2)
+	last.With(`quote"back\slash`).Set(1651363200)
+	last.With("gone").Set(1)
+	last.Delete("gone")
+	latency.With("success").Observe(0.5)
+	latency.With("success").Observe(0.7)
+	latency.With("success").Observe(3)
+
+	if v := attempts.With("a").Value(); v != 2 {
+		t.Errorf("expect 2, got %v", v)
+	}
+	want := `# HELP test_attempts_total Attempts.
+# TYPE test_attempts_total counter
+test_attempts_total{account="a"} 2
+test_attempts_total{account="b"} 1
+# HELP test_idle_total Never increased.
+# TYPE test_idle_total counter
+test_idle_total 0
+# HELP test_last_seconds Last time,\nin seconds.
+# TYPE test_last_seconds gauge
+test_last_seconds{account="quote\"back\\slash"} 1.6513632e+09
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{result="success",le="0.5"} 1
+test_latency_seconds_bucket{result="success",le="1"} 2
+test_latency_seconds_bucket{result="success",le="+Inf"} 3
+test_latency_seconds_sum{result="success"} 4.2
+test_latency_seconds_count{result="success"} 3
+`
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Body.String(); got != want {
+		t.Errorf("expect:\n%s\ngot:\n%s", want, got)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test.", "a", "b")
+	for name, f := range map[string]func(){
+		"duplicate":      func() { r.NewGaugeVec("test_total", "Test.") },
+		"label count":    func() { c.With("x") },
+		"negative":       func() { c.With("x", "y").Add(-1) },
+		"unsorted bound": func() { r.NewHistogramVec("test_seconds", "Test.", []float64{1, 0.5}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expect a panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
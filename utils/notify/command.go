@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils/config"
+)
+
+// command run a local command with the event in json on stdin
+type command struct {
+	Command []string        `json:"command"` // the program and its arguments
+	Timeout config.Duration `json:"timeout"` // default: 30s
+}
+
+func newCommand(raw json.RawMessage) (serve.Notifier, error) {
+	c := &command{}
+	if err := decode(raw, c); err != nil {
+		return nil, err
+	}
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return nil, fmt.Errorf("%w: command: command is required", ErrInvalidChannel)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = config.Duration(30 * time.Second)
+	}
+	return c, nil
+}
+
+// Notify implement serve.Notifier
+func (c *command) Notify(e serve.Event) error {
+	input, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	output := &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = output, output
+	if err = cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("command: %w, output: %s", err, out)
+		}
+		return fmt.Errorf("command: %w", err)
+	}
+	return nil
+}
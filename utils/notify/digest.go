@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils"
+)
+
+// EventDigest the summary of the results of all the accounts
+const EventDigest serve.EventType = "digest"
+
+// DigestPeriod how often the digest is sent
+type DigestPeriod string
+
+const (
+	// DigestNone no digest
+	DigestNone DigestPeriod = ""
+	// DigestDaily send the digest of the last day every day
+	DigestDaily DigestPeriod = "daily"
+	// DigestWeekly send the digest of the last week every week
+	DigestWeekly DigestPeriod = "weekly"
+)
+
+// DigestSchedule when to send the digest
+type DigestSchedule struct {
+	Period  DigestPeriod
+	Hour    int
+	Minute  int
+	Weekday time.Weekday // for DigestWeekly only
+}
+
+// ParseDigestSchedule parse the period(`daily`, `weekly` or empty for no digest),
+// the time(HH:MM, default 21:00) and the weekday of the weekly digest(e.g. sun or
+// sunday, default sunday)
+func ParseDigestSchedule(period, at, weekday string) (DigestSchedule, error) {
+	s := DigestSchedule{Period: DigestPeriod(period), Hour: 21}
+	switch s.Period {
+	case DigestNone:
+		return s, nil
+	case DigestDaily, DigestWeekly:
+	default:
+		return s, fmt.Errorf("%w: unknown digest: %q", ErrInvalidChannel, period)
+	}
+	if at != "" {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return s, fmt.Errorf("%w: invalid digestAt: %q", ErrInvalidChannel, at)
+		}
+		s.Hour, s.Minute = t.Hour(), t.Minute()
+	}
+	if weekday != "" {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := strings.ToLower(d.String())
+			if w := strings.ToLower(weekday); w == name || w == name[:3] {
+				s.Weekday = d
+				return s, nil
+			}
+		}
+		return s, fmt.Errorf("%w: invalid digestDay: %q", ErrInvalidChannel, weekday)
+	}
+	return s, nil
+}
+
+// Next return the first time to send the digest after the time, in the location of the time
+func (s DigestSchedule) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	if s.Period == DigestWeekly {
+		day += (int(s.Weekday) - int(after.Weekday()) + 7) % 7
+	}
+	t := time.Date(year, month, day, s.Hour, s.Minute, 0, 0, after.Location())
+	if !t.After(after) {
+		t = t.AddDate(0, 0, s.days())
+	}
+	return t
+}
+
+// days return the days covered by the digest
+func (s DigestSchedule) days() int {
+	if s.Period == DigestWeekly {
+		return 7
+	}
+	return 1
+}
+
+// Collector collect the punch results for the digests, the results older than
+// a week are dropped, and they are kept in memory only, so the digest after a
+// restart covers the results since the restart
+type Collector struct {
+	mux    sync.Mutex
+	events []serve.Event
+}
+
+// NewCollector return an empty collector
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+// Add add the event if it is a punch result
+func (c *Collector) Add(e serve.Event) {
+	switch e.Type {
+	case serve.EventSuccess, serve.EventRecovered, serve.EventFinalFailure, serve.EventSkipped:
+	default:
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	expired := e.Time.AddDate(0, 0, -8)
+	i := 0
+	for i < len(c.events) && c.events[i].Time.Before(expired) {
+		i++
+	}
+	c.events = append(c.events[i:], e)
+}
+
+// accountDigest the results of an account in a digest
+type accountDigest struct {
+	counts    map[serve.EventType]int
+	lastError serve.Event // the last final failure
+}
+
+// Digest return the digest event of the results in the period ending at end
+func (c *Collector) Digest(s DigestSchedule, channel string, end time.Time) serve.Event {
+	start := end.AddDate(0, 0, -s.days())
+	accounts := make(map[string]*accountDigest)
+	var names []string
+	c.mux.Lock()
+	for _, e := range c.events {
+		if e.Time.Before(start) || !e.Time.Before(end) {
+			continue
+		}
+		a, ok := accounts[e.Account]
+		if !ok {
+			a = &accountDigest{counts: make(map[serve.EventType]int)}
+			accounts[e.Account] = a
+			names = append(names, e.Account)
+		}
+		a.counts[e.Type]++
+		if e.Type == serve.EventFinalFailure {
+			a.lastError = e
+		}
+	}
+	c.mux.Unlock()
+	sort.Strings(names)
+
+	title := "打卡日报"
+	if s.Period == DigestWeekly {
+		title = "打卡周报"
+	}
+	body := &strings.Builder{}
+	fmt.Fprintf(body, "%s 至 %s 打卡汇总\n", start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"))
+	if len(names) == 0 {
+		body.WriteString("无打卡记录\n")
+	}
+	for _, name := range names {
+		a := accounts[name]
+		fmt.Fprintf(body, "账户: %s 成功 %d 次, 重试后成功 %d 次, 失败 %d 次, 跳过 %d 次",
+			name, a.counts[serve.EventSuccess], a.counts[serve.EventRecovered],
+			a.counts[serve.EventFinalFailure], a.counts[serve.EventSkipped])
+		if e := a.lastError; e.Type != "" {
+			fmt.Fprintf(body, ", 最近失败: %s(类型: %s, err: %s)", e.Time.In(end.Location()).Format("01-02 15:04"), e.Kind, e.Error)
+		}
+		body.WriteByte('\n')
+	}
+	return serve.Event{
+		ID:      "digest-" + channel + "-" + strconv.FormatInt(end.Unix(), 10),
+		Type:    EventDigest,
+		Time:    end,
+		Subject: title + "-" + end.Format("2006-01-02"),
+		Body:    body.String(),
+	}
+}
+
+// Run send the digests of the channels on their schedules in the location by send
+// until the context is canceled, the errors of send are passed to onError
+func (c *Collector) Run(ctx context.Context, n Notifiers, loc *time.Location, send func(ch Channel, e serve.Event) error, onError func(channel string, err error)) {
+	for {
+		now := time.Now().In(loc)
+		var (
+			next time.Time
+			due  []Channel
+		)
+		for _, ch := range n {
+			if ch.Digest.Period == DigestNone {
+				continue
+			}
+			switch t := ch.Digest.Next(now); {
+			case next.IsZero() || t.Before(next):
+				next, due = t, []Channel{ch}
+			case t.Equal(next):
+				due = append(due, ch)
+			}
+		}
+		if next.IsZero() || utils.Wait(ctx, next.Sub(now)) != nil {
+			return
+		}
+		for _, ch := range due {
+			if err := send(ch, c.Digest(ch.Digest, ch.Name, next)); err != nil && onError != nil {
+				onError(ch.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+var cst = time.FixedZone("CST", 8*3600)
+
+func TestDigestSchedule(t *testing.T) {
+	daily, err := ParseDigestSchedule("daily", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekly, err := ParseDigestSchedule("weekly", "08:30", "Mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thursday := time.Date(2022, 5, 5, 21, 0, 0, 0, cst)
+	tests := []struct {
+		schedule DigestSchedule
+		after    time.Time
+		next     time.Time
+	}{
+		{daily, thursday.Add(-time.Minute), thursday},
+		{daily, thursday, thursday.AddDate(0, 0, 1)},
+		{weekly, thursday, time.Date(2022, 5, 9, 8, 30, 0, 0, cst)},
+		{weekly, time.Date(2022, 5, 9, 8, 0, 0, 0, cst), time.Date(2022, 5, 9, 8, 30, 0, 0, cst)},
+		{weekly, time.Date(2022, 5, 9, 8, 30, 0, 0, cst), time.Date(2022, 5, 16, 8, 30, 0, 0, cst)},
+	}
+	for _, tt := range tests {
+		if next := tt.schedule.Next(tt.after); !next.Equal(tt.next) {
+			t.Errorf("%+v after %v: expect %v, got %v", tt.schedule, tt.after, tt.next, next)
+		}
+	}
+
+	for _, args := range [][3]string{{"hourly", "", ""}, {"daily", "25:00", ""}, {"weekly", "", "someday"}} {
+		if _, err := ParseDigestSchedule(args[0], args[1], args[2]); !errors.Is(err, ErrInvalidChannel) {
+			t.Errorf("%v: expect ErrInvalidChannel, got %v", args, err)
+		}
+	}
+}
+
+func TestCollectorDigest(t *testing.T) {
+	end := time.Date(2022, 5, 5, 21, 0, 0, 0, cst)
+	c := NewCollector()
+	for _, e := range []serve.Event{
+		{Type: serve.EventSuccess, Account: "b", Time: end.Add(-25 * time.Hour)}, // out of the day
+		{Type: serve.EventFirstFailure, Account: "a", Time: end.Add(-13 * time.Hour)},
+		{Type: serve.EventRecovered, Account: "a", Time: end.Add(-12 * time.Hour)},
+		{Type: serve.EventSuccess, Account: "b", Time: end.Add(-12 * time.Hour)},
+		{Type: serve.EventFinalFailure, Account: "b", Time: end.Add(-time.Hour), Kind: "auth", Error: "wrong password"},
+		{Type: serve.EventSuccess, Account: "b", Time: end}, // the next day
+	} {
+		c.Add(e)
+	}
+
+	daily := DigestSchedule{Period: DigestDaily, Hour: 21}
+	e := c.Digest(daily, "mail", end)
+	want := "2022-05-04 21:00 至 2022-05-05 21:00 打卡汇总\n" +
+		"账户: a 成功 0 次, 重试后成功 1 次, 失败 0 次, 跳过 0 次\n" +
+		"账户: b 成功 1 次, 重试后成功 0 次, 失败 1 次, 跳过 0 次, 最近失败: 05-05 20:00(类型: auth, err: wrong password)\n"
+	if e.Body != want {
+		t.Errorf("expect body:\n%s\ngot:\n%s", want, e.Body)
+	}
+	if e.Type != EventDigest || e.Subject != "打卡日报-2022-05-05" || e.ID != "digest-mail-1651755600" {
+		t.Errorf("unexpected digest: %+v", e)
+	}
+
+	e = c.Digest(DigestSchedule{Period: DigestWeekly}, "mail", end.AddDate(0, 0, 7))
+	if !strings.HasPrefix(e.Subject, "打卡周报") || !strings.Contains(e.Body, "账户: b 成功 1 次") {
+		t.Errorf("unexpected weekly digest: %+v", e)
+	}
+	if e = c.Digest(daily, "mail", end.AddDate(0, 0, 3)); !strings.Contains(e.Body, "无打卡记录") {
+		t.Errorf("expect no records, got %q", e.Body)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	var got []string
+	record := func(name string) serve.Notifier {
+		return serve.NotifierFunc(func(e serve.Event) error {
+			got = append(got, name+":"+string(e.Type))
+			return nil
+		})
+	}
+	n := Notifiers{
+		{Name: "default", Notifier: record("default")},
+		{Name: "all", Events: eventTypes, Notifier: record("all")},
+		{Name: "none", Events: []serve.EventType{}, Notifier: record("none")},
+	}
+	for _, typ := range []serve.EventType{serve.EventSuccess, serve.EventFinalFailure} {
+		n.Notify(serve.Event{Type: typ})
+	}
+	want := []string{"all:success", "default:final-failure", "all:final-failure"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect %v, got %v", want, got)
+	}
+
+	n, err := New(Config{Channels: []json.RawMessage{json.RawMessage(
+		`{"type":"webhook","url":"http://localhost","events":["success","recovered"],"digest":"weekly","digestDay":"friday"}`,
+	)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := n[0]; !reflect.DeepEqual(c.Events, []serve.EventType{serve.EventSuccess, serve.EventRecovered}) ||
+		c.Digest != (DigestSchedule{Period: DigestWeekly, Hour: 21, Weekday: time.Friday}) {
+		t.Errorf("unexpected channel: %+v", c)
+	}
+	if _, err = New(Config{Channels: []json.RawMessage{json.RawMessage(`{"type":"webhook","url":"http://localhost","events":["sometimes"]}`)}}); !errors.Is(err, ErrInvalidChannel) {
+		t.Errorf("expect ErrInvalidChannel, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+// Package notify deliver the punch events to the notification channels
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+var (
+	// ErrUnknownChannel the channel type is not registered
+	ErrUnknownChannel = errors.New("notify: unknown channel type")
+	// ErrInvalidChannel the channel config is invalid
+	ErrInvalidChannel = errors.New("notify: invalid channel config")
+)
+
+// httpClient the client used by the http based channels, replaced in tests
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Factory create a channel from its json config
+type Factory func(config json.RawMessage) (serve.Notifier, error)
+
+var (
+	mux       sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register register the factory of a channel type, it panics if the type
+// is registered twice
+func Register(typ string, f Factory) {
+	mux.Lock()
+	defer mux.Unlock()
+	if _, ok := factories[typ]; ok {
+		panic("notify: channel type registered twice: " + typ)
+	}
+	factories[typ] = f
+}
+
+// Types return the registered channel types
+func Types() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func init() {
+	Register("smtp", newSMTP)
+	Register("webhook", newWebhook)
+	Register("url", newURLTemplate)
+	Register("telegram", newTelegram)
+	Register("command", newCommand)
+}
+
+// DefaultEvents the events subscribed by the channels without the `events` key
+var DefaultEvents = []serve.EventType{serve.EventFinalFailure, serve.EventSkipped}
+
+// eventTypes the events which can be subscribed
+var eventTypes = []serve.EventType{
+	serve.EventSuccess, serve.EventFirstFailure, serve.EventRecovered,
+	serve.EventFinalFailure, serve.EventSkipped,
+}
+
+// Channel a configured notification channel
+type Channel struct {
+	Name   string
+	Type   string
+	Events []serve.EventType // the subscribed events, nil for DefaultEvents
+	Digest DigestSchedule    // when to send the digest, zero for no digest
+	serve.Notifier
+}
+
+// Subscribed report whether the channel subscribes the event type
+func (c Channel) Subscribed(typ serve.EventType) bool {
+	events := c.Events
+	if events == nil {
+		events = DefaultEvents
+	}
+	for _, t := range events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifiers the channels which every event is delivered to
+type Notifiers []Channel
+
+// Config the notification config
+type Config struct {
+	// Channels the channel configs, each has a `type` key and the optional keys:
+	//  - name: the name in the logs
+	//  - events: the subscribed events, e.g. ["success", "first-failure", "recovered", "final-failure", "skipped"],
+	//    DefaultEvents if not set, [] for no event
+	//  - digest: `daily` or `weekly` to send a summary of all the accounts,
+	//    at `digestAt`(HH:MM, default 21:00) on `digestDay`(weekly only, default sunday)
+	// the other keys are decided by the type
+	Channels []json.RawMessage `json:"channels"`
+}
+
+// New create the channels of the config
+func New(cfg Config) (Notifiers, error) {
+	n := make(Notifiers, 0, len(cfg.Channels))
+	for i, raw := range cfg.Channels {
+		var head struct {
+			Type      string            `json:"type"`
+			Name      string            `json:"name"`
+			Events    []serve.EventType `json:"events"`
+			Digest    string            `json:"digest"`
+			DigestAt  string            `json:"digestAt"`
+			DigestDay string            `json:"digestDay"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return nil, fmt.Errorf("%w: channel %d: %s", ErrInvalidChannel, i, err.Error())
+		}
+		if head.Name == "" {
+			head.Name = fmt.Sprintf("%s#%d", head.Type, i)
+		}
+		if err := checkEvents(head.Events); err != nil {
+			return nil, fmt.Errorf("%s: %w", head.Name, err)
+		}
+		digest, err := ParseDigestSchedule(head.Digest, head.DigestAt, head.DigestDay)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", head.Name, err)
+		}
+		mux.RLock()
+		f, ok := factories[head.Type]
+		mux.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %q(%s)", ErrUnknownChannel, head.Type, head.Name)
+		}
+		notifier, err := f(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", head.Name, err)
+		}
+		n = append(n, Channel{
+			Name:     head.Name,
+			Type:     head.Type,
+			Events:   head.Events,
+			Digest:   digest,
+			Notifier: notifier,
+		})
+	}
+	return n, nil
+}
+
+// Load load the notification config from the json file and create the channels
+func Load(path string) (Notifiers, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cfg Config
+	if err = json.NewDecoder(f).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidChannel, err.Error())
+	}
+	return New(cfg)
+}
+
+// checkEvents check whether the events can be subscribed
+func checkEvents(events []serve.EventType) error {
+next:
+	for _, e := range events {
+		for _, t := range eventTypes {
+			if e == t {
+				continue next
+			}
+		}
+		return fmt.Errorf("%w: unknown event: %q", ErrInvalidChannel, e)
+	}
+	return nil
+}
+
+// Notify implement serve.Notifier, deliver the event to the channels subscribing it,
+// the errors of the channels are joined
+func (n Notifiers) Notify(e serve.Event) error {
+	var errs []string
+	for _, c := range n {
+		if !c.Subscribed(e.Type) {
+			continue
+		}
+		if err := c.Notify(e); err != nil {
+			errs = append(errs, c.Name+": "+err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// addressed is implemented by the channels whose receivers can be
+// overridden for an account
+type addressed interface {
+	WithReceivers(to []string) serve.Notifier
+}
+
+// WithReceivers return the channels whose receivers(e.g. the email addresses)
+// are replaced by to, the channels without receivers are kept
+func (n Notifiers) WithReceivers(to []string) Notifiers {
+	if len(to) == 0 {
+		return n
+	}
+	res := make(Notifiers, len(n))
+	for i, c := range n {
+		if a, ok := c.Notifier.(addressed); ok {
+			c.Notifier = a.WithReceivers(to)
+		}
+		res[i] = c
+	}
+	return res
+}
+
+// decode decode the config of a channel
+func decode(raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidChannel, err.Error())
+	}
+	return nil
+}
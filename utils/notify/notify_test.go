@@ -0,0 +1,225 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils/email"
+)
+
+var testEvent = serve.Event{
+	ID:      "test-final-failure-1",
+	Type:    serve.EventFinalFailure,
+	Account: "test",
+	Time:    time.Date(2022, 5, 5, 8, 0, 0, 0, time.UTC),
+	Subject: "打卡状态推送-2022-05-05",
+	Body:    "账户: test 打卡失败",
+	Attempt: 3,
+	Kind:    "auth",
+	Message: "密码错误",
+	Error:   "login: 密码错误",
+}
+
+// request a request received by the test server
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   string
+}
+
+// newServer start a server recording the requests and replying with the status and the body
+func newServer(t *testing.T, status int, reply string) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Method, r.URL.Path, r.URL.Query(), r.Header, string(body)}
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(s.Close)
+	return s, requests
+}
+
+// newChannel create a channel from the json config
+func newChannel(t *testing.T, config string) Notifiers {
+	t.Helper()
+	n, err := New(Config{Channels: []json.RawMessage{json.RawMessage(config)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWebhook(t *testing.T) {
+	s, requests := newServer(t, http.StatusNoContent, "")
+	n := newChannel(t, `{"type":"webhook","url":"`+s.URL+`/hook","headers":{"Authorization":"Bearer secret"}}`)
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.method != http.MethodPost || r.path != "/hook" || r.header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected request: %+v", r)
+	}
+	var e serve.Event
+	if err := json.Unmarshal([]byte(r.body), &e); err != nil || !reflect.DeepEqual(e, testEvent) {
+		t.Errorf("expect the event %+v, got %s(err: %v)", testEvent, r.body, err)
+	}
+
+	s, _ = newServer(t, http.StatusInternalServerError, "")
+	n = newChannel(t, `{"type":"webhook","name":"ops","url":"`+s.URL+`"}`)
+	if err := n.Notify(testEvent); err == nil || !strings.Contains(err.Error(), "ops: webhook: unexpected status: 500") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestURLTemplate(t *testing.T) {
+	s, requests := newServer(t, http.StatusOK, `{"code":0}`)
+
+	// ServerChan style
+	n := newChannel(t, `{"type":"url","url":"`+s.URL+`/KEY.send?title={{urlquery .Subject}}&desp={{urlquery .Body}}"}`)
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.method != http.MethodGet || r.path != "/KEY.send" ||
+		r.query.Get("title") != testEvent.Subject || r.query.Get("desp") != testEvent.Body {
+		t.Errorf("unexpected request: %+v", r)
+	}
+
+	// PushPlus style
+	n = newChannel(t, `{"type":"url","url":"`+s.URL+`/send","contentType":"application/json",
+		"body":"{\"token\":\"KEY\",\"title\":{{json .Subject}},\"content\":{{json .Body}}}"}`)
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	r = <-requests
+	var body map[string]string
+	if err := json.Unmarshal([]byte(r.body), &body); err != nil {
+		t.Fatalf("invalid body: %s(err: %v)", r.body, err)
+	}
+	want := map[string]string{"token": "KEY", "title": testEvent.Subject, "content": testEvent.Body}
+	if r.method != http.MethodPost || r.header.Get("Content-Type") != "application/json" || !reflect.DeepEqual(body, want) {
+		t.Errorf("unexpected request: %+v", r)
+	}
+
+	if _, err := New(Config{Channels: []json.RawMessage{json.RawMessage(`{"type":"url","url":"{{.Subject"}`)}}); !errors.Is(err, ErrInvalidChannel) {
+		t.Errorf("expect ErrInvalidChannel, got %v", err)
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	s, requests := newServer(t, http.StatusOK, `{"ok":true}`)
+	n := newChannel(t, `{"type":"telegram","token":"123:abc","chatID":"42","apiURL":"`+s.URL+`/"}`)
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	var body map[string]string
+	json.Unmarshal([]byte(r.body), &body)
+	if r.path != "/bot123:abc/sendMessage" || body["chat_id"] != "42" || !strings.Contains(body["text"], testEvent.Body) {
+		t.Errorf("unexpected request: %+v", r)
+	}
+
+	s, _ = newServer(t, http.StatusBadRequest, `{"ok":false,"description":"Bad Request: chat not found"}`)
+	n = newChannel(t, `{"type":"telegram","token":"123:abc","chatID":"42","apiURL":"`+s.URL+`"}`)
+	if err := n.Notify(testEvent); err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("expect the description in the error, got %v", err)
+	}
+
+	// the token is not leaked by the url in the error
+	s.Close()
+	err := n.Notify(testEvent)
+	if err == nil || strings.Contains(err.Error(), "123:abc") || !strings.Contains(err.Error(), "/bot<redacted>/sendMessage") {
+		t.Errorf("expect the token redacted, got %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "event.json")
+	config, _ := json.Marshal(map[string]interface{}{
+		"type":    "command",
+		"command": []string{"sh", "-c", `cat > "$0"`, out},
+	})
+	if err := newChannel(t, string(config)).Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e serve.Event
+	if err = json.Unmarshal(data, &e); err != nil || !reflect.DeepEqual(e, testEvent) {
+		t.Errorf("expect the event %+v on stdin, got %s(err: %v)", testEvent, data, err)
+	}
+
+	n := newChannel(t, `{"type":"command","command":["sh","-c","echo failed >&2; exit 3"]}`)
+	if err = n.Notify(testEvent); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("expect the output in the error, got %v", err)
+	}
+	n = newChannel(t, `{"type":"command","command":["sleep","10"],"timeout":"10ms"}`)
+	if err = n.Notify(testEvent); err == nil {
+		t.Error("expect an error on timeout")
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	var sent []*email.Config
+	old := sendMail
+	sendMail = func(cfg *email.Config, nickName string, m email.Message) error {
+		if nickName != DefaultNickName || m.Subject != testEvent.Subject ||
+			!strings.Contains(m.Text, testEvent.Body) || !strings.Contains(m.HTML, testEvent.Body) {
+			t.Errorf("unexpected mail: %s, %+v", nickName, m)
+		}
+		sent = append(sent, cfg)
+		return nil
+	}
+	defer func() { sendMail = old }()
+
+	n := newChannel(t, `{"type":"smtp","to":["a@example.com"],"SMTP":{"host":"smtp.example.com","port":465}}`)
+	n.Notify(testEvent)
+	n.WithReceivers([]string{"b@example.com"}).Notify(testEvent)
+	n.WithReceivers(nil).Notify(testEvent)
+	var to [][]string
+	for _, cfg := range sent {
+		to = append(to, cfg.To)
+	}
+	want := [][]string{{"a@example.com"}, {"b@example.com"}, {"a@example.com"}}
+	if !reflect.DeepEqual(to, want) {
+		t.Errorf("expect receivers %v, got %v", want, to)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		err    error
+	}{
+		{`{"type":"pigeon"}`, ErrUnknownChannel},
+		{`{"type":"webhook"}`, ErrInvalidChannel},
+		{`{"type":"telegram","token":"t"}`, ErrInvalidChannel},
+		{`{"type":"command","command":[]}`, ErrInvalidChannel},
+		{`{"type":"smtp","to":"a@example.com"}`, ErrInvalidChannel},
+		{`[]`, ErrInvalidChannel},
+	} {
+		if _, err := New(Config{Channels: []json.RawMessage{json.RawMessage(tt.config)}}); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expect %v, got %v", tt.config, tt.err, err)
+		}
+	}
+	if types := Types(); !reflect.DeepEqual(types, []string{"command", "smtp", "telegram", "url", "webhook"}) {
+		t.Errorf("unexpected types: %v", types)
+	}
+}
@@ -0,0 +1,407 @@
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+// ErrMessageNotFound the message is not in the queue
+var ErrMessageNotFound = errors.New("notify: message not found")
+
+const (
+	// DefaultMaxAttempts the attempts before a message is stuck
+	DefaultMaxAttempts = 10
+
+	queueExt      = ".json"
+	pollInterval  = time.Minute    // rescan the spool for the messages retried by the notify-queue command
+	keepDelivered = 24 * time.Hour // how long the delivered keys are kept for deduplication
+	minBackoff    = 30 * time.Second
+	maxBackoff    = time.Hour
+)
+
+// Message a queued event for a channel
+type Message struct {
+	Key       string      `json:"key"`
+	Channel   string      `json:"channel"`
+	To        []string    `json:"to,omitempty"`
+	Event     serve.Event `json:"event"`
+	Created   time.Time   `json:"created"`
+	Attempts  int         `json:"attempts"`
+	NextRetry time.Time   `json:"nextRetry,omitempty"`
+	LastError string      `json:"lastError,omitempty"`
+}
+
+// Stuck report whether the message is not retried anymore
+func (m *Message) Stuck(maxAttempts int) bool {
+	return m.Attempts >= maxAttempts
+}
+
+// Backoff return the delay before the next attempt after the failed attempts,
+// it starts at 30s and doubles up to an hour
+func Backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// queueKey return the key of the event for the channel
+func queueKey(eventID, channel string) string {
+	sum := sha256.Sum256([]byte(channel + "\x00" + eventID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Queue a durable spool of the events, each event is stored in a file per subscribing
+// channel and delivered in the background with backoff until it succeeds or gets stuck
+type Queue struct {
+	dir string
+	// MaxAttempts the attempts before a message is stuck, default: DefaultMaxAttempts
+	MaxAttempts int
+
+	mux       sync.Mutex
+	channels  Notifiers
+	delivered map[string]time.Time // the keys delivered recently
+	wake      chan struct{}
+
+	sending sync.Mutex // only one delivery at a time
+	now     func() time.Time
+}
+
+// OpenQueue open the queue in the directory, the directory is created if not exists
+func OpenQueue(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Queue{
+		dir:         dir,
+		MaxAttempts: DefaultMaxAttempts,
+		delivered:   make(map[string]time.Time),
+		wake:        make(chan struct{}, 1),
+		now:         time.Now,
+	}, nil
+}
+
+// SetChannels set the channels the messages are delivered to, the messages of
+// the channels not configured are kept and retried
+func (q *Queue) SetChannels(n Notifiers) {
+	q.mux.Lock()
+	q.channels = n
+	q.mux.Unlock()
+}
+
+// Enqueue store the event for the channels subscribing it, the receivers replace the
+// ones of the channels if not empty, an event already queued or delivered is ignored
+func (q *Queue) Enqueue(e serve.Event, to []string) error {
+	return q.enqueue(e, to, func(c Channel) bool { return c.Subscribed(e.Type) })
+}
+
+// EnqueueChannel store the event for the channel of the name regardless of its
+// subscriptions, e.g. the digest of the channel
+func (q *Queue) EnqueueChannel(name string, e serve.Event) error {
+	return q.enqueue(e, nil, func(c Channel) bool { return c.Name == name })
+}
+
+func (q *Queue) enqueue(e serve.Event, to []string, match func(c Channel) bool) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	now := q.now()
+	for key, t := range q.delivered {
+		if now.Sub(t) > keepDelivered {
+			delete(q.delivered, key)
+		}
+	}
+	added := false
+	for _, c := range q.channels {
+		if !match(c) {
+			continue
+		}
+		m := &Message{
+			Key:       queueKey(e.ID, c.Name),
+			Channel:   c.Name,
+			To:        to,
+			Event:     e,
+			Created:   now,
+			NextRetry: now,
+		}
+		if _, ok := q.delivered[m.Key]; ok {
+			continue
+		}
+		if _, err := os.Stat(q.path(m.Key)); err == nil {
+			continue
+		}
+		if err := q.write(m); err != nil {
+			return err
+		}
+		added = true
+	}
+	if added {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run deliver the due messages until the context is canceled,
+// the delivery errors are passed to onError
+func (q *Queue) Run(ctx context.Context, onError func(m Message, err error)) {
+	for {
+		next := q.deliver(ctx, false, onError)
+		wait := pollInterval
+		if !next.IsZero() {
+			if d := next.Sub(q.now()); d < wait {
+				wait = d
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Flush try to deliver all the pending messages once ignoring the backoff, the stuck
+// messages are skipped, it returns the number of the messages left in the queue and
+// the context error if it is done before the delivery finishes
+func (q *Queue) Flush(ctx context.Context, onError func(m Message, err error)) (int, error) {
+	done := make(chan struct{})
+	go func() {
+		q.deliver(ctx, true, onError)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		messages, _ := q.List()
+		return len(messages), ctx.Err()
+	}
+	messages, err := q.List()
+	return len(messages), err
+}
+
+// deliver deliver the due(or all if all is true) messages which are not stuck,
+// it returns the earliest retry time of the messages left, zero if none
+func (q *Queue) deliver(ctx context.Context, all bool, onError func(m Message, err error)) time.Time {
+	q.sending.Lock()
+	defer q.sending.Unlock()
+	messages, err := q.List()
+	if err != nil && onError != nil {
+		onError(Message{}, err)
+	}
+	var next time.Time
+	for i := range messages {
+		m := &messages[i]
+		if m.Stuck(q.maxAttempts()) {
+			continue
+		}
+		if !all && m.NextRetry.After(q.now()) {
+			if next.IsZero() || m.NextRetry.Before(next) {
+				next = m.NextRetry
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return next
+		}
+		if err = q.send(m); err == nil {
+			continue
+		}
+		if onError != nil {
+			onError(*m, err)
+		}
+		if !m.Stuck(q.maxAttempts()) && (next.IsZero() || m.NextRetry.Before(next)) {
+			next = m.NextRetry
+		}
+	}
+	return next
+}
+
+// send deliver the message, the message is removed on success,
+// otherwise the attempt is recorded
+func (q *Queue) send(m *Message) error {
+	q.mux.Lock()
+	var channel *Channel
+	for i := range q.channels {
+		if q.channels[i].Name == m.Channel {
+			channel = &q.channels[i]
+			break
+		}
+	}
+	q.mux.Unlock()
+
+	var err error
+	if channel == nil {
+		err = fmt.Errorf("channel %s is not configured", m.Channel)
+	} else {
+		notifier := channel.Notifier
+		if a, ok := notifier.(addressed); ok && len(m.To) != 0 {
+			notifier = a.WithReceivers(m.To)
+		}
+		err = notifier.Notify(m.Event)
+	}
+	if err == nil {
+		q.mux.Lock()
+		q.delivered[m.Key] = q.now()
+		q.mux.Unlock()
+		if err = os.Remove(q.path(m.Key)); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	m.Attempts++
+	m.LastError = err.Error()
+	m.NextRetry = q.now().Add(Backoff(m.Attempts))
+	if werr := q.write(m); werr != nil {
+		return fmt.Errorf("%w(save failed: %s)", err, werr.Error())
+	}
+	return err
+}
+
+func (q *Queue) maxAttempts() int {
+	if q.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return q.MaxAttempts
+}
+
+// List return the queued messages in the order of creation
+func (q *Queue) List() ([]Message, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		messages []Message
+		errs     []string
+	)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, queueExt) {
+			continue
+		}
+		m, err := q.read(strings.TrimSuffix(name, queueExt))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) { // delivered meanwhile
+				errs = append(errs, name+": "+err.Error())
+			}
+			continue
+		}
+		messages = append(messages, *m)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Created.Before(messages[j].Created)
+	})
+	if len(errs) != 0 {
+		err = errors.New("notify: invalid queued message: " + strings.Join(errs, "; "))
+	}
+	return messages, err
+}
+
+// Retry reset the attempts of the message so that it is delivered as soon as possible,
+// all the stuck messages are reset if the key is empty, it returns the number of the
+// messages reset
+func (q *Queue) Retry(key string) (int, error) {
+	if key != "" {
+		m, err := q.read(key)
+		if err != nil {
+			return 0, q.notFound(key, err)
+		}
+		return 1, q.reset(m)
+	}
+	messages, err := q.List()
+	n := 0
+	for i := range messages {
+		if !messages[i].Stuck(q.maxAttempts()) {
+			continue
+		}
+		if err := q.reset(&messages[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, err
+}
+
+// Remove drop the message from the queue
+func (q *Queue) Remove(key string) error {
+	if err := os.Remove(q.path(key)); err != nil {
+		return q.notFound(key, err)
+	}
+	return nil
+}
+
+func (q *Queue) reset(m *Message) error {
+	m.Attempts = 0
+	m.NextRetry = q.now()
+	return q.write(m)
+}
+
+func (q *Queue) notFound(key string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrMessageNotFound, key)
+	}
+	return err
+}
+
+func (q *Queue) path(key string) string {
+	return filepath.Join(q.dir, filepath.Base(key)+queueExt)
+}
+
+func (q *Queue) read(key string) (*Message, error) {
+	data, err := os.ReadFile(q.path(key))
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// write store the message atomically
+func (q *Queue) write(m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(q.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), q.path(m.Key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
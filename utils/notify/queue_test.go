@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+// flaky a channel failing until it is fixed
+type flaky struct {
+	mux    sync.Mutex
+	broken bool
+	events []serve.Event
+}
+
+func (f *flaky) Notify(e serve.Event) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.broken {
+		return errors.New("connection refused")
+	}
+	f.events = append(f.events, e)
+	return nil
+}
+
+func (f *flaky) setBroken(broken bool) {
+	f.mux.Lock()
+	f.broken = broken
+	f.mux.Unlock()
+}
+
+func (f *flaky) count() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.events)
+}
+
+// newTestQueue open a queue in a temporary directory with a fake clock
+func newTestQueue(t *testing.T, dir string, channels ...Channel) (*Queue, *time.Time) {
+	t.Helper()
+	q, err := OpenQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 5, 5, 8, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	q.SetChannels(channels)
+	return q, &now
+}
+
+func pending(t *testing.T, q *Queue) []Message {
+	t.Helper()
+	messages, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("%d: expect %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	mail, hook := &flaky{broken: true}, &flaky{}
+	channels := []Channel{
+		{Name: "mail", Notifier: mail},
+		{Name: "hook", Events: []serve.EventType{serve.EventSuccess}, Notifier: hook},
+	}
+	q, now := newTestQueue(t, dir, channels...)
+	q.MaxAttempts = 3
+	ctx := context.Background()
+
+	// only the subscribing channel, and only once
+	for i := 0; i < 2; i++ {
+		if err := q.Enqueue(testEvent, []string{"b@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := pending(t, q)
+	if len(messages) != 1 || messages[0].Channel != "mail" || messages[0].To[0] != "b@example.com" {
+		t.Fatalf("unexpected messages: %+v", messages)
+	}
+
+	// retried with backoff until stuck
+	var errs int
+	onError := func(m Message, err error) { errs++ }
+	if next := q.deliver(ctx, false, onError); !next.Equal(now.Add(30 * time.Second)) {
+		t.Errorf("unexpected next retry: %s", next)
+	}
+	q.deliver(ctx, false, onError) // not due
+	*now = now.Add(30 * time.Second)
+	q.deliver(ctx, false, onError)
+	*now = now.Add(time.Minute)
+	if next := q.deliver(ctx, false, onError); !next.IsZero() {
+		t.Errorf("expect no retry of the stuck message, got %s", next)
+	}
+	messages = pending(t, q)
+	if errs != 3 || len(messages) != 1 || !messages[0].Stuck(q.MaxAttempts) || messages[0].LastError != "connection refused" {
+		t.Fatalf("expect a stuck message after 3 errors, got %d errors: %+v", errs, messages)
+	}
+
+	// the queue is durable, the stuck message is delivered after retried
+	mail.setBroken(false)
+	q, _ = newTestQueue(t, dir, channels...)
+	q.MaxAttempts = 3
+	if n, err := q.Retry(""); n != 1 || err != nil {
+		t.Fatalf("expect 1 message retried, got %d(err: %v)", n, err)
+	}
+	q.deliver(ctx, false, nil)
+	if len(pending(t, q)) != 0 || mail.count() != 1 || mail.events[0].ID != testEvent.ID {
+		t.Fatalf("expect the message delivered, got %v", mail.events)
+	}
+
+	// the delivered events are not queued again
+	q.Enqueue(testEvent, nil)
+	if len(pending(t, q)) != 0 {
+		t.Error("expect the delivered event ignored")
+	}
+
+	if _, err := q.Retry("missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expect ErrMessageNotFound, got %v", err)
+	}
+	if err := q.Remove("missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expect ErrMessageNotFound, got %v", err)
+	}
+}
+
+func TestQueueFlush(t *testing.T) {
+	mail := &flaky{broken: true}
+	q, now := newTestQueue(t, t.TempDir(), Channel{Name: "mail", Notifier: mail})
+	ctx := context.Background()
+	e := testEvent
+	for i := 0; i < 3; i++ {
+		e.ID = testEvent.ID + string(rune('a'+i))
+		q.Enqueue(e, nil)
+		*now = now.Add(time.Second)
+	}
+	q.deliver(ctx, false, nil)
+
+	// the backoff is ignored
+	mail.setBroken(false)
+	if n, err := q.Flush(ctx, nil); n != 0 || err != nil {
+		t.Fatalf("expect the queue flushed, got %d left(err: %v)", n, err)
+	}
+	if mail.count() != 3 || mail.events[0].ID != testEvent.ID+"a" {
+		t.Errorf("expect the events delivered in order, got %v", mail.events)
+	}
+
+	// the channel is removed
+	q.Enqueue(testEvent, nil)
+	q.SetChannels(nil)
+	if n, err := q.Flush(ctx, nil); n != 1 || err != nil {
+		t.Errorf("expect 1 message left, got %d(err: %v)", n, err)
+	}
+	m := pending(t, q)[0]
+	if err := q.Remove(m.Key); err != nil || len(pending(t, q)) != 0 {
+		t.Errorf("expect the message removed, err: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	q.SetChannels(Notifiers{{Name: "mail", Notifier: mail}})
+	q.Enqueue(testEvent, nil)
+	if n, err := q.Flush(canceled, nil); n != 1 || !errors.Is(err, context.Canceled) {
+		t.Errorf("expect 1 message left on canceled, got %d(err: %v)", n, err)
+	}
+}
+
+func TestQueueEnqueueChannel(t *testing.T) {
+	mail, hook := &flaky{}, &flaky{}
+	q, _ := newTestQueue(t, t.TempDir(),
+		Channel{Name: "mail", Notifier: mail},
+		Channel{Name: "hook", Events: []serve.EventType{EventDigest}, Notifier: hook},
+	)
+	digest := serve.Event{ID: "digest-mail-1", Type: EventDigest, Subject: "打卡日报"}
+	if err := q.EnqueueChannel("mail", digest); err != nil {
+		t.Fatal(err)
+	}
+	messages := pending(t, q)
+	if len(messages) != 1 || messages[0].Channel != "mail" {
+		t.Fatalf("expect the digest queued for mail only, got %+v", messages)
+	}
+	q.deliver(context.Background(), false, nil)
+	if mail.count() != 1 || hook.count() != 0 {
+		t.Errorf("expect the digest delivered to mail only, got %d and %d", mail.count(), hook.count())
+	}
+}
+
+func TestQueueRun(t *testing.T) {
+	mail := &flaky{}
+	q, err := OpenQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.SetChannels(Notifiers{{Name: "mail", Notifier: mail}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, nil)
+		close(done)
+	}()
+	q.Enqueue(testEvent, nil)
+	for i := 0; i < 100 && mail.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if mail.count() != 1 {
+		t.Errorf("expect the event delivered in the background, got %d", mail.count())
+	}
+}
@@ -0,0 +1,157 @@
+package notify
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils/email"
+)
+
+// DefaultNickName the default display name of the email sender
+const DefaultNickName = "打卡状态推送"
+
+// sendMail send the email, replaced in tests
+var sendMail = (*email.Config).SendMessage
+
+var (
+	//go:embed templates/email.txt
+	defaultText string
+	//go:embed templates/email.html
+	defaultHTML string
+)
+
+// eventNames the display names of the event types
+var eventNames = map[serve.EventType]string{
+	serve.EventSuccess:      "打卡成功",
+	serve.EventFirstFailure: "首次打卡失败",
+	serve.EventRecovered:    "重试后打卡成功",
+	serve.EventFinalFailure: "打卡失败",
+	serve.EventSkipped:      "跳过打卡",
+	EventDigest:             "打卡汇总",
+}
+
+// emailFuncs the functions available in the email templates
+var emailFuncs = map[string]interface{}{
+	"eventName": func(typ serve.EventType) string {
+		if name, ok := eventNames[typ]; ok {
+			return name
+		}
+		return string(typ)
+	},
+	"lines": func(s string) []string {
+		return strings.Split(strings.TrimRight(s, "\n"), "\n")
+	},
+}
+
+// Templates the templates rendering the email bodies, both are executed with the serve.Event
+type Templates struct {
+	Text *template.Template
+	HTML *htmltemplate.Template
+}
+
+// DefaultTemplates the built-in email templates
+var DefaultTemplates = Templates{
+	Text: template.Must(template.New("email.txt").Funcs(emailFuncs).Parse(defaultText)),
+	HTML: htmltemplate.Must(htmltemplate.New("email.html").Funcs(emailFuncs).Parse(defaultHTML)),
+}
+
+// LoadTemplates load the email templates from the files,
+// the default template is used if the path is empty
+func LoadTemplates(textPath, htmlPath string) (Templates, error) {
+	t := DefaultTemplates
+	if textPath != "" {
+		data, err := os.ReadFile(textPath)
+		if err != nil {
+			return t, err
+		}
+		if t.Text, err = template.New(textPath).Funcs(emailFuncs).Parse(string(data)); err != nil {
+			return t, fmt.Errorf("%w: %s", ErrInvalidChannel, err.Error())
+		}
+	}
+	if htmlPath != "" {
+		data, err := os.ReadFile(htmlPath)
+		if err != nil {
+			return t, err
+		}
+		if t.HTML, err = htmltemplate.New(htmlPath).Funcs(emailFuncs).Parse(string(data)); err != nil {
+			return t, fmt.Errorf("%w: %s", ErrInvalidChannel, err.Error())
+		}
+	}
+	return t, nil
+}
+
+// Render render the email of the event
+func (t Templates) Render(e serve.Event) (email.Message, error) {
+	m := email.Message{Subject: e.Subject}
+	text, html := &strings.Builder{}, &strings.Builder{}
+	if err := t.Text.Execute(text, e); err != nil {
+		return m, err
+	}
+	if err := t.HTML.Execute(html, e); err != nil {
+		return m, err
+	}
+	m.Text, m.HTML = text.String(), html.String()
+	return m, nil
+}
+
+// smtpChannel send the events by email
+type smtpChannel struct {
+	cfg       email.Config
+	nickName  string
+	templates Templates
+}
+
+type smtpConfig struct {
+	email.Config
+	NickName     string `json:"nickName"`
+	TextTemplate string `json:"textTemplate"` // the path of the plain text template
+	HTMLTemplate string `json:"htmlTemplate"` // the path of the html template
+}
+
+func newSMTP(raw json.RawMessage) (serve.Notifier, error) {
+	var cfg smtpConfig
+	if err := decode(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.SMTP.Host == "" {
+		return nil, fmt.Errorf("%w: smtp: host is required", ErrInvalidChannel)
+	}
+	templates, err := LoadTemplates(cfg.TextTemplate, cfg.HTMLTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: load templates failed(Err: %w)", err)
+	}
+	c := NewSMTP(&cfg.Config, cfg.NickName).(*smtpChannel)
+	c.templates = templates
+	return c, nil
+}
+
+// NewSMTP return a channel sending the events by email with the default templates,
+// the default nick name is used if empty
+func NewSMTP(cfg *email.Config, nickName string) serve.Notifier {
+	if nickName == "" {
+		nickName = DefaultNickName
+	}
+	return &smtpChannel{cfg: *cfg, nickName: nickName, templates: DefaultTemplates}
+}
+
+// Notify implement serve.Notifier
+func (c *smtpChannel) Notify(e serve.Event) error {
+	m, err := c.templates.Render(e)
+	if err != nil {
+		return fmt.Errorf("smtp: render email failed(Err: %w)", err)
+	}
+	return sendMail(&c.cfg, c.nickName, m)
+}
+
+// WithReceivers implement addressed
+func (c *smtpChannel) WithReceivers(to []string) serve.Notifier {
+	res := *c
+	res.cfg.To = to
+	return &res
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yin1999/healthreport/v2/serve"
+	"github.com/yin1999/healthreport/v2/utils/email"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// golden compare the data with the golden file in testdata, the file is rewritten with -update
+func golden(t *testing.T, name string, data string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(want) != data {
+		t.Errorf("%s: mismatch, want:\n%s\ngot:\n%s", name, want, data)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	for name, e := range map[string]serve.Event{
+		"final-failure": testEvent,
+		"recovered": {
+			Type:    serve.EventRecovered,
+			Account: "test",
+			Time:    time.Date(2022, 5, 5, 8, 3, 0, 0, cst),
+			Subject: "打卡状态推送-2022-05-05",
+			Body:    "账户: test 打卡成功(尝试 2 次)",
+			Attempt: 2,
+		},
+		"skipped": {
+			Type:    serve.EventSkipped,
+			Account: "<script>",
+			Time:    time.Date(2022, 10, 1, 8, 0, 0, 0, cst),
+			Subject: "打卡状态推送-2022-10-01",
+			Body:    "账户: <script> 跳过打卡(skipped: holiday(国庆节))",
+			Message: "国庆节",
+		},
+		"digest": {
+			Type:    EventDigest,
+			Time:    time.Date(2022, 5, 5, 21, 0, 0, 0, cst),
+			Subject: "打卡日报-2022-05-05",
+			Body:    "2022-05-04 21:00 至 2022-05-05 21:00 打卡汇总\n账户: test 成功 1 次, 重试后成功 0 次, 失败 0 次, 跳过 0 次\n",
+		},
+	} {
+		m, err := DefaultTemplates.Render(e)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if m.Subject != e.Subject {
+			t.Errorf("%s: expect subject %q, got %q", name, e.Subject, m.Subject)
+		}
+		golden(t, name+".txt", m.Text)
+		golden(t, name+".html", m.HTML)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "email.txt")
+	os.WriteFile(text, []byte(`{{.Account}} {{eventName .Type}} {{.Attempt}} {{.Kind}} {{.Message}}`), 0600)
+	templates, err := LoadTemplates(text, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := templates.Render(testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "test 打卡失败 3 auth 密码错误" {
+		t.Errorf("unexpected text: %q", m.Text)
+	}
+	if def, _ := DefaultTemplates.Render(testEvent); m.HTML != def.HTML {
+		t.Error("expect the default html template")
+	}
+
+	html := filepath.Join(dir, "email.html")
+	os.WriteFile(html, []byte(`{{.Account`), 0600)
+	if _, err = LoadTemplates("", html); !errors.Is(err, ErrInvalidChannel) {
+		t.Errorf("expect ErrInvalidChannel, got %v", err)
+	}
+	if _, err = LoadTemplates(filepath.Join(dir, "missing"), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expect ErrNotExist, got %v", err)
+	}
+
+	var sent email.Message
+	old := sendMail
+	sendMail = func(cfg *email.Config, nickName string, m email.Message) error {
+		sent = m
+		return nil
+	}
+	defer func() { sendMail = old }()
+	n := newChannel(t, `{"type":"smtp","to":["a@example.com"],"SMTP":{"host":"smtp.example.com"},"textTemplate":"`+text+`"}`)
+	if err = n.Notify(testEvent); err != nil || sent.Text != m.Text {
+		t.Errorf("expect the text template of the channel, got %q(err: %v)", sent.Text, err)
+	}
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+// DefaultTelegramAPI the default url of the telegram bot api
+const DefaultTelegramAPI = "https://api.telegram.org"
+
+// telegram send the events by a telegram bot
+type telegram struct {
+	Token  string `json:"token"`
+	ChatID string `json:"chatID"`
+	APIURL string `json:"apiURL"` // default: DefaultTelegramAPI
+}
+
+func newTelegram(raw json.RawMessage) (serve.Notifier, error) {
+	t := &telegram{}
+	if err := decode(raw, t); err != nil {
+		return nil, err
+	}
+	if t.Token == "" || t.ChatID == "" {
+		return nil, fmt.Errorf("%w: telegram: token and chatID are required", ErrInvalidChannel)
+	}
+	if t.APIURL == "" {
+		t.APIURL = DefaultTelegramAPI
+	}
+	t.APIURL = strings.TrimSuffix(t.APIURL, "/")
+	return t, nil
+}
+
+// Notify implement serve.Notifier
+func (t *telegram) Notify(e serve.Event) error {
+	body, err := json.Marshal(map[string]string{
+		"chat_id": t.ChatID,
+		"text":    e.Subject + "\n" + e.Body,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.APIURL+"/bot"+t.Token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return t.redact(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := do("telegram", req)
+	err = t.redact(err)
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if jerr := json.Unmarshal(res, &result); jerr == nil && !result.OK {
+		// the description explains the error better than the status
+		return fmt.Errorf("telegram: %s", result.Description)
+	}
+	return err
+}
+
+// redact hide the token in the url of the error, the error is logged and queued
+func (t *telegram) redact(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := *urlErr
+	redacted.URL = strings.ReplaceAll(urlErr.URL, "/bot"+t.Token, "/bot<redacted>")
+	return &redacted
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/yin1999/healthreport/v2/serve"
+)
+
+// webhook post the events in json
+type webhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+func newWebhook(raw json.RawMessage) (serve.Notifier, error) {
+	w := &webhook{}
+	if err := decode(raw, w); err != nil {
+		return nil, err
+	}
+	if w.URL == "" {
+		return nil, fmt.Errorf("%w: webhook: url is required", ErrInvalidChannel)
+	}
+	return w, nil
+}
+
+// Notify implement serve.Notifier
+func (w *webhook) Notify(e serve.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	_, err = do("webhook", req)
+	return err
+}
+
+// urlTemplate request the url built from the templates, for the push
+// services like ServerChan and PushPlus. The templates are executed with
+// the serve.Event, and the `json` function quotes a string in json.
+type urlTemplate struct {
+	method      string
+	url         *template.Template
+	body        *template.Template // nil for no body
+	contentType string
+	headers     map[string]string
+}
+
+type urlTemplateConfig struct {
+	Method      string            `json:"method"` // default: GET without body, POST with body
+	URL         string            `json:"url"`
+	Body        string            `json:"body"`
+	ContentType string            `json:"contentType"` // default: application/x-www-form-urlencoded
+	Headers     map[string]string `json:"headers"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newURLTemplate(raw json.RawMessage) (serve.Notifier, error) {
+	var cfg urlTemplateConfig
+	if err := decode(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: url: url is required", ErrInvalidChannel)
+	}
+	t := &urlTemplate{
+		method:      strings.ToUpper(cfg.Method),
+		contentType: cfg.ContentType,
+		headers:     cfg.Headers,
+	}
+	var err error
+	if t.url, err = template.New("url").Funcs(templateFuncs).Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("%w: url: %s", ErrInvalidChannel, err.Error())
+	}
+	if cfg.Body != "" {
+		if t.body, err = template.New("body").Funcs(templateFuncs).Parse(cfg.Body); err != nil {
+			return nil, fmt.Errorf("%w: url: %s", ErrInvalidChannel, err.Error())
+		}
+	}
+	if t.method == "" {
+		t.method = http.MethodGet
+		if t.body != nil {
+			t.method = http.MethodPost
+		}
+	}
+	if t.contentType == "" {
+		t.contentType = "application/x-www-form-urlencoded"
+	}
+	return t, nil
+}
+
+// Notify implement serve.Notifier
+func (t *urlTemplate) Notify(e serve.Event) error {
+	u := &strings.Builder{}
+	if err := t.url.Execute(u, e); err != nil {
+		return err
+	}
+	var body io.Reader
+	if t.body != nil {
+		b := &bytes.Buffer{}
+		if err := t.body.Execute(b, e); err != nil {
+			return err
+		}
+		body = b
+	}
+	req, err := http.NewRequest(t.method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", t.contentType)
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	_, err = do("url", req)
+	return err
+}
+
+// do send the request and return the response body, the status
+// other than 2xx is an error
+func do(channel string, req *http.Request) ([]byte, error) {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return body, fmt.Errorf("%s: unexpected status: %s", channel, res.Status)
+	}
+	return body, nil
+}